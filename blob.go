@@ -0,0 +1,567 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Riak doesn't cope well with values larger than a few megabytes,
+// so blobs are split into chunks that are stored as objects of their
+// own, and the blob's key holds a manifest that lists the chunks.
+// Chunks are written under keys that are unique to each upload, so
+// that a blob can be overwritten without disturbing readers of the
+// old version until its manifest has been replaced, e.g. the chunks
+// of "video.mp4" are stored as "video.mp4.<upload id>.0", and so on.
+
+// manifests are stored with this content type,
+// so that they can be told apart from other objects
+const manifestType = "application/x-riak-blob-manifest+json"
+
+// ErrNotBlob is returned when a blob is read from
+// a key that holds an object that isn't a manifest
+var ErrNotBlob = errors.New("riak: object is not a blob manifest")
+
+// ErrCorruptManifest is returned when a blob's manifest
+// can't be decoded, or its chunks don't add up to its size
+var ErrCorruptManifest = errors.New("riak: blob manifest is corrupt")
+
+// ErrCorruptChunk is returned when a chunk of a blob
+// doesn't match the size or checksum in its manifest
+type ErrCorruptChunk struct {
+	Bucket string
+	Key    string
+}
+
+func (e *ErrCorruptChunk) Error() string {
+	return "riak: blob chunk " + e.Bucket + "/" + e.Key + " doesn't match its manifest"
+}
+
+// Manifest describes a blob
+type Manifest struct {
+	Ctype       string  `json:"content_type"`
+	Size        int64   `json:"size"`
+	ChunkSize   int     `json:"chunk_size"`
+	ChunkBucket string  `json:"chunk_bucket"`
+	Chunks      []Chunk `json:"chunks"`
+}
+
+// Chunk is one piece of a blob
+type Chunk struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+	Sum  string `json:"sha256"` // hex-encoded
+}
+
+// BlobOptions control how blobs are stored and deleted.
+// The zero value is usable.
+type BlobOptions struct {
+	// ChunkSize is the size of every chunk but the
+	// last one; it defaults to 1MB.
+	ChunkSize int
+
+	// Parallelism is the number of chunks that are
+	// stored or deleted at once; it defaults to 4.
+	// Up to Parallelism+1 chunks are held in memory
+	// while a blob is stored.
+	Parallelism int
+
+	// ChunkBucket is the bucket that chunks are stored
+	// in; it defaults to the blob's bucket with a
+	// "_chunks" suffix, so that listing the keys of
+	// the blob's bucket only lists manifests.
+	ChunkBucket string
+
+	// StoreOpts are passed to StoreReader and Modify when
+	// chunks and manifests are stored, e.g. {"w": "all"}
+	StoreOpts map[string]string
+}
+
+func (b *BlobOptions) chunkSize() int {
+	if b.ChunkSize <= 0 {
+		return 1 << 20
+	}
+	return b.ChunkSize
+}
+
+func (b *BlobOptions) parallelism() int {
+	if b.Parallelism <= 0 {
+		return 4
+	}
+	return b.Parallelism
+}
+
+func (b *BlobOptions) chunkBucket(bucket string) string {
+	if b.ChunkBucket == "" {
+		return bucket + "_chunks"
+	}
+	return b.ChunkBucket
+}
+
+// upload ids start with the time of the upload,
+// so that CollectBlobGarbage can tell how old
+// chunks are without fetching them
+func uploadID(now time.Time) string {
+	var b [4]byte
+	rand.Read(b[:])
+	return strconv.FormatInt(now.UnixNano(), 36) + "-" + hex.EncodeToString(b[:])
+}
+
+func chunkKey(key string, id string, n int) string {
+	return key + "." + id + "." + strconv.Itoa(n)
+}
+
+// parseChunkKey returns the blob key and upload
+// time of a chunk key, or ok=false if 'ckey'
+// isn't a chunk key
+func parseChunkKey(ckey string) (key string, created time.Time, ok bool) {
+	i := strings.LastIndexByte(ckey, '.')
+	if i < 0 {
+		return "", created, false
+	}
+	if _, err := strconv.Atoi(ckey[i+1:]); err != nil {
+		return "", created, false
+	}
+	j := strings.LastIndexByte(ckey[:i], '.')
+	if j < 0 {
+		return "", created, false
+	}
+	id := ckey[j+1 : i]
+	dash := strings.IndexByte(id, '-')
+	if dash < 0 {
+		return "", created, false
+	}
+	nanos, err := strconv.ParseInt(id[:dash], 36, 64)
+	if err != nil {
+		return "", created, false
+	}
+	return ckey[:j], time.Unix(0, nanos), true
+}
+
+// group runs functions concurrently, up to a limit,
+// and cancels the rest when one of them fails
+type group struct {
+	parent context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+func newGroup(ctx context.Context, limit int) (*group, context.Context) {
+	gctx, cancel := context.WithCancel(ctx)
+	return &group{parent: ctx, cancel: cancel, sem: make(chan struct{}, limit)}, gctx
+}
+
+// run waits until fewer than 'limit' functions are running
+// and calls 'f' in a new goroutine. It returns false without
+// calling 'f' if the group has been cancelled.
+func (g *group) run(ctx context.Context, f func() error) bool {
+	select {
+	case g.sem <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	if ctx.Err() != nil {
+		<-g.sem
+		return false
+	}
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			<-g.sem
+			g.wg.Done()
+		}()
+		if err := f(); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+	return true
+}
+
+// wait returns the first error returned by a function,
+// or the parent context's error
+func (g *group) wait() error {
+	g.wg.Wait()
+	g.cancel()
+	if g.err == nil {
+		return g.parent.Err()
+	}
+	return g.err
+}
+
+// PutBlob stores the contents of 'r' as a blob at bucket/key.
+// The contents are split into chunks, which are stored
+// concurrently, and then a manifest of the chunks is stored at
+// bucket/key. If a blob already existed at bucket/key, its chunks
+// are deleted once its manifest has been replaced; readers of the
+// old blob may fail with ErrNotFound. If an object that isn't a
+// blob exists at bucket/key, PutBlob returns ErrNotBlob instead of
+// overwriting it. If PutBlob fails, the chunks that it stored are
+// deleted, and the old blob is left in place. Chunks that couldn't
+// be deleted are left for CollectBlobGarbage.
+// 'opts' may be nil.
+//
+//	f, _ := os.Open("video.mp4")
+//	m, err := c.PutBlob("videos", "intro.mp4", f, "video/mp4", nil)
+func (c *Client) PutBlob(bucket string, key string, r io.Reader, ctype string, opts *BlobOptions) (*Manifest, error) {
+	return c.PutBlobContext(context.Background(), bucket, key, r, ctype, opts)
+}
+
+// PutBlobContext is like PutBlob, but the requests are
+// abandoned if 'ctx' is done before they complete.
+func (c *Client) PutBlobContext(ctx context.Context, bucket string, key string, r io.Reader, ctype string, opts *BlobOptions) (*Manifest, error) {
+	if opts == nil {
+		opts = &BlobOptions{}
+	}
+	m := &Manifest{
+		Ctype:       ctype,
+		ChunkSize:   opts.chunkSize(),
+		ChunkBucket: opts.chunkBucket(bucket),
+	}
+	if err := c.putChunks(ctx, m, key, r, opts); err != nil {
+		// 'ctx' may be done
+		c.deleteChunks(context.Background(), m.ChunkBucket, m.Chunks, opts.parallelism())
+		return nil, err
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var old *Manifest
+	o, err := c.ModifyContext(ctx, bucket, key, func(o *Object) error {
+		old = nil
+		if o.Vclock != "" && mediaType(o.Ctype) != manifestType {
+			return ErrNotBlob
+		}
+		// a corrupt manifest's chunks are left
+		// for CollectBlobGarbage
+		old, _ = decodeManifest(o)
+		o.Ctype = manifestType
+		o.Body.Reset()
+		o.Body.Write(body)
+		return nil
+	}, &ModifyOptions{StoreOpts: opts.StoreOpts})
+	if err != nil {
+		c.deleteChunks(context.Background(), m.ChunkBucket, m.Chunks, opts.parallelism())
+		return nil, err
+	}
+	Release(o)
+	if old != nil {
+		// the blob is stored; anything left
+		// over is CollectBlobGarbage's problem
+		c.deleteChunks(ctx, old.ChunkBucket, old.Chunks, opts.parallelism())
+	}
+	return m, nil
+}
+
+// read 'r' into chunks, store them, and add them to 'm'
+func (c *Client) putChunks(ctx context.Context, m *Manifest, key string, r io.Reader, opts *BlobOptions) error {
+	id := uploadID(time.Now())
+	g, gctx := newGroup(ctx, opts.parallelism())
+	var rerr error
+	for i := 0; ; i++ {
+		buf := make([]byte, m.ChunkSize)
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			rerr = err
+			break
+		}
+		buf = buf[:n]
+		sum := sha256.Sum256(buf)
+		ch := Chunk{Key: chunkKey(key, id, i), Size: n, Sum: hex.EncodeToString(sum[:])}
+		if !g.run(gctx, func() error {
+			o := &Object{Bucket: m.ChunkBucket, Key: ch.Key, Ctype: "application/octet-stream"}
+			return c.StoreReaderContext(gctx, o, bytes.NewReader(buf), int64(n), opts.StoreOpts)
+		}) {
+			break
+		}
+		m.Chunks = append(m.Chunks, ch)
+		m.Size += int64(n)
+		if n < m.ChunkSize {
+			break
+		}
+	}
+	if err := g.wait(); err != nil {
+		return err
+	}
+	return rerr
+}
+
+// delete chunks, ignoring chunks that are already gone
+func (c *Client) deleteChunks(ctx context.Context, bucket string, chunks []Chunk, par int) error {
+	g, gctx := newGroup(ctx, par)
+	for _, ch := range chunks {
+		key := ch.Key
+		if !g.run(gctx, func() error {
+			err := c.DeleteContext(gctx, &Object{Bucket: bucket, Key: key}, nil)
+			if err == ErrNotFound {
+				return nil
+			}
+			return err
+		}) {
+			break
+		}
+	}
+	return g.wait()
+}
+
+func decodeManifest(o *Object) (*Manifest, error) {
+	if mediaType(o.Ctype) != manifestType {
+		return nil, ErrNotBlob
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(o.Body.Bytes(), m); err != nil {
+		return nil, ErrCorruptManifest
+	}
+	// Blob relies on every chunk holding some of the blob
+	var size int64
+	for _, ch := range m.Chunks {
+		if ch.Size <= 0 {
+			return nil, ErrCorruptManifest
+		}
+		size += int64(ch.Size)
+	}
+	if size != m.Size {
+		return nil, ErrCorruptManifest
+	}
+	return m, nil
+}
+
+// DeleteBlob deletes the blob at bucket/key and its chunks.
+// The blob is gone once its manifest has been deleted, even
+// if an error is returned while deleting its chunks; those
+// are left for CollectBlobGarbage. 'opts' may be nil.
+func (c *Client) DeleteBlob(bucket string, key string, opts *BlobOptions) error {
+	return c.DeleteBlobContext(context.Background(), bucket, key, opts)
+}
+
+// DeleteBlobContext is like DeleteBlob, but the requests
+// are abandoned if 'ctx' is done before they complete.
+func (c *Client) DeleteBlobContext(ctx context.Context, bucket string, key string, opts *BlobOptions) error {
+	if opts == nil {
+		opts = &BlobOptions{}
+	}
+	o, err := c.FetchContext(ctx, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	defer Release(o)
+	m, err := decodeManifest(o)
+	if err != nil {
+		return err
+	}
+	if err := c.DeleteContext(ctx, o, nil); err != nil {
+		return err
+	}
+	return c.deleteChunks(ctx, m.ChunkBucket, m.Chunks, opts.parallelism())
+}
+
+// CollectBlobGarbage deletes the chunks in the chunk bucket of
+// 'bucket' that aren't part of a blob, e.g. because a PutBlob
+// failed part of the way through, or because the chunks of an
+// overwritten or deleted blob couldn't all be deleted. Chunks that
+// were stored less than 'olderThan' ago are left alone, since they
+// may belong to a blob that's still being stored. It returns the
+// number of chunks that were deleted. CollectBlobGarbage lists
+// every key in the chunk bucket, which is expensive; it shouldn't
+// be run often. 'opts' may be nil.
+func (c *Client) CollectBlobGarbage(bucket string, olderThan time.Duration, opts *BlobOptions) (int, error) {
+	return c.CollectBlobGarbageContext(context.Background(), bucket, olderThan, opts)
+}
+
+// CollectBlobGarbageContext is like CollectBlobGarbage, but the
+// requests are abandoned if 'ctx' is done before they complete.
+func (c *Client) CollectBlobGarbageContext(ctx context.Context, bucket string, olderThan time.Duration, opts *BlobOptions) (int, error) {
+	if opts == nil {
+		opts = &BlobOptions{}
+	}
+	cbucket := opts.chunkBucket(bucket)
+	keys, err := c.ListBucketKeysContext(ctx, cbucket)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	live := make(map[string]map[string]bool) // blob key -> its chunks
+	var garbage []Chunk
+	for _, ckey := range keys {
+		key, created, ok := parseChunkKey(ckey)
+		if !ok || created.After(cutoff) {
+			continue
+		}
+		chunks, ok := live[key]
+		if !ok {
+			chunks = make(map[string]bool)
+			o, err := c.FetchContext(ctx, bucket, key, nil)
+			switch err {
+			case nil:
+				if m, err := decodeManifest(o); err == nil {
+					for _, ch := range m.Chunks {
+						chunks[ch.Key] = true
+					}
+				}
+				Release(o)
+			case ErrNotFound:
+			default:
+				return 0, err
+			}
+			live[key] = chunks
+		}
+		if !chunks[ckey] {
+			garbage = append(garbage, Chunk{Key: ckey})
+		}
+	}
+	if err := c.deleteChunks(ctx, cbucket, garbage, opts.parallelism()); err != nil {
+		return 0, err
+	}
+	return len(garbage), nil
+}
+
+// Blob reads a blob. Only the chunks that are read are
+// fetched, so Seek and ReadAt can be used to read ranges of
+// large blobs cheaply. Blobs can be served with http.ServeContent:
+//
+//	b, err := c.OpenBlob("videos", "intro.mp4")
+//	if err != nil {
+//		...
+//	}
+//	w.Header().Set("Content-Type", b.Manifest.Ctype)
+//	http.ServeContent(w, req, "intro.mp4", time.Time{}, b)
+//
+// Read and Seek aren't safe to call concurrently, but ReadAt is.
+type Blob struct {
+	Manifest *Manifest
+
+	c    *Client
+	ctx  context.Context
+	offs []int64 // the offset of each chunk
+	pos  int64
+	cur  int    // the index of 'buf' in the manifest, or -1
+	buf  []byte // the last chunk read by Read
+}
+
+// OpenBlob fetches the manifest of the blob at bucket/key.
+// It returns ErrNotBlob if the object at bucket/key isn't
+// a blob.
+func (c *Client) OpenBlob(bucket string, key string) (*Blob, error) {
+	return c.OpenBlobContext(context.Background(), bucket, key)
+}
+
+// OpenBlobContext is like OpenBlob, but the request is abandoned,
+// as are the requests made when the blob is read, if 'ctx' is done
+// before they complete.
+func (c *Client) OpenBlobContext(ctx context.Context, bucket string, key string) (*Blob, error) {
+	o, err := c.FetchContext(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	m, err := decodeManifest(o)
+	Release(o)
+	if err != nil {
+		return nil, err
+	}
+	b := &Blob{Manifest: m, c: c, ctx: ctx, offs: make([]int64, len(m.Chunks)), cur: -1}
+	var off int64
+	for i, ch := range m.Chunks {
+		b.offs[i] = off
+		off += int64(ch.Size)
+	}
+	return b, nil
+}
+
+// the index of the chunk that holds the byte at 'off'
+func (b *Blob) chunkAt(off int64) int {
+	return sort.Search(len(b.offs), func(i int) bool { return b.offs[i] > off }) - 1
+}
+
+// fetch and check chunk 'i'
+func (b *Blob) chunk(i int) ([]byte, error) {
+	ch := &b.Manifest.Chunks[i]
+	o, err := b.c.FetchContext(b.ctx, b.Manifest.ChunkBucket, ch.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 'o' isn't released, since its body is returned
+	data := o.Body.Bytes()
+	sum := sha256.Sum256(data)
+	if len(data) != ch.Size || hex.EncodeToString(sum[:]) != ch.Sum {
+		return nil, &ErrCorruptChunk{Bucket: b.Manifest.ChunkBucket, Key: ch.Key}
+	}
+	return data, nil
+}
+
+// Read implements io.Reader
+func (b *Blob) Read(p []byte) (int, error) {
+	if b.pos >= b.Manifest.Size {
+		return 0, io.EOF
+	}
+	i := b.chunkAt(b.pos)
+	if i != b.cur {
+		data, err := b.chunk(i)
+		if err != nil {
+			return 0, err
+		}
+		b.buf, b.cur = data, i
+	}
+	n := copy(p, b.buf[b.pos-b.offs[i]:])
+	b.pos += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += b.Manifest.Size
+	default:
+		return 0, errors.New("riak: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("riak: negative position")
+	}
+	b.pos = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("riak: negative offset")
+	}
+	n := 0
+	for n < len(p) && off < b.Manifest.Size {
+		i := b.chunkAt(off)
+		data, err := b.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		k := copy(p[n:], data[off-b.offs[i]:])
+		n += k
+		off += int64(k)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
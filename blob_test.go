@@ -0,0 +1,272 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/philhofer/riak/riaktest"
+)
+
+func chunkKeys(m *Manifest) []string {
+	keys := make([]string, len(m.Chunks))
+	for i, ch := range m.Chunks {
+		keys[i] = ch.Key
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestBlob(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	var (
+		mu                sync.Mutex
+		gets              []string
+		inflight, maxPuts int32
+	)
+	c := NewClient(srv.URL, "testClient", WrapTransport(func(tr Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			switch req.Method {
+			case "GET":
+				mu.Lock()
+				gets = append(gets, req.URL.Path)
+				mu.Unlock()
+			case "PUT":
+				n := atomic.AddInt32(&inflight, 1)
+				defer atomic.AddInt32(&inflight, -1)
+				for {
+					max := atomic.LoadInt32(&maxPuts)
+					if n <= max || atomic.CompareAndSwapInt32(&maxPuts, max, n) {
+						break
+					}
+				}
+			}
+			return tr.Do(req)
+		})
+	}))
+	srv.Inject(riaktest.Fault{Method: "PUT", Path: "/riak/files_chunks/", Delay: 5 * time.Millisecond})
+
+	data := make([]byte, 2500)
+	rand.New(rand.NewSource(1)).Read(data)
+	opts := &BlobOptions{ChunkSize: 1000, Parallelism: 2}
+	m, err := c.PutBlob("files", "data.bin", bytes.NewReader(data), "application/octet-stream", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size != 2500 || len(m.Chunks) != 3 || m.Chunks[2].Size != 500 || m.ChunkBucket != "files_chunks" {
+		t.Errorf("bad manifest %+v", m)
+	}
+	if max := atomic.LoadInt32(&maxPuts); max > 2 {
+		t.Errorf("%d chunks were stored at once; expected at most 2", max)
+	}
+
+	b, err := c.OpenBlob("files", "data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Manifest.Ctype != "application/octet-stream" {
+		t.Errorf("bad content type %q", b.Manifest.Ctype)
+	}
+	got, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("read the wrong contents")
+	}
+
+	// ranges only fetch the chunks they need
+	gets = nil
+	got = make([]byte, 200)
+	if _, err := b.ReadAt(got, 1900); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[1900:2100]) {
+		t.Error("ReadAt read the wrong contents")
+	}
+	if len(gets) != 2 || !strings.HasSuffix(gets[0], ".1") || !strings.HasSuffix(gets[1], ".2") {
+		t.Errorf("expected the second and third chunks to be fetched; got %v", gets)
+	}
+	if _, err := b.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(b); !bytes.Equal(got, data[2490:]) {
+		t.Error("read the wrong contents after Seek")
+	}
+	if n, err := b.ReadAt(make([]byte, 100), 2450); n != 50 || err != io.EOF {
+		t.Errorf("ReadAt past the end: %d %v", n, err)
+	}
+
+	// overwriting collects the old chunks
+	m2, err := c.PutBlob("files", "data.bin", strings.NewReader("small"), "text/plain", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := c.ListBucketKeys("files_chunks")
+	sort.Strings(keys)
+	if strings.Join(keys, " ") != strings.Join(chunkKeys(m2), " ") {
+		t.Errorf("expected chunks %v; got %v", chunkKeys(m2), keys)
+	}
+	// readers of the old version notice
+	if _, err := b.ReadAt(got, 0); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	// corruption is detected
+	c.Store(&Object{Bucket: "files_chunks", Key: m2.Chunks[0].Key, Body: bytes.NewBufferString("SMALL")}, nil)
+	b, err = c.OpenBlob("files", "data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(b); err == nil {
+		t.Error("expected an error for a corrupt chunk")
+	} else if _, ok := err.(*ErrCorruptChunk); !ok {
+		t.Errorf("expected ErrCorruptChunk; got %v", err)
+	}
+
+	// empty blobs
+	if m, err := c.PutBlob("files", "empty", strings.NewReader(""), "text/plain", nil); err != nil || len(m.Chunks) != 0 {
+		t.Fatalf("empty blob: %+v %v", m, err)
+	}
+	if b, err := c.OpenBlob("files", "empty"); err != nil {
+		t.Fatal(err)
+	} else if got, err := io.ReadAll(b); len(got) != 0 || err != nil {
+		t.Errorf("read %q %v from an empty blob", got, err)
+	}
+
+	if err := c.DeleteBlob("files", "data.bin", nil); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := c.ListBucketKeys("files_chunks"); len(keys) != 0 {
+		t.Errorf("chunks left after DeleteBlob: %v", keys)
+	}
+	if _, err := c.OpenBlob("files", "data.bin"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	c.Store(&Object{Bucket: "files", Key: "plain", Body: bytes.NewBufferString("not a blob")}, nil)
+	if _, err := c.OpenBlob("files", "plain"); err != ErrNotBlob {
+		t.Errorf("expected ErrNotBlob; got %v", err)
+	}
+}
+
+func TestBlobFailure(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+	opts := &BlobOptions{ChunkSize: 10, ChunkBucket: "chunks"}
+	old, err := c.PutBlob("files", "f", strings.NewReader("the old contents"), "text/plain", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed upload cleans up after itself
+	srv.Inject(riaktest.Fault{Method: "PUT", Path: "/riak/chunks/", Status: 500, Times: 1})
+	if _, err := c.PutBlob("files", "f", strings.NewReader(strings.Repeat("x", 100)), "text/plain", opts); err == nil {
+		t.Fatal("expected an error")
+	}
+	// as does a failure to store the manifest
+	srv.Inject(riaktest.Fault{Method: "PUT", Path: "/riak/files/", Status: 500, Times: 1})
+	if _, err := c.PutBlob("files", "f", strings.NewReader(strings.Repeat("x", 100)), "text/plain", opts); err == nil {
+		t.Fatal("expected an error")
+	}
+	keys, _ := c.ListBucketKeys("chunks")
+	sort.Strings(keys)
+	if strings.Join(keys, " ") != strings.Join(chunkKeys(old), " ") {
+		t.Errorf("expected chunks %v; got %v", chunkKeys(old), keys)
+	}
+	b, err := c.OpenBlob("files", "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(b); string(got) != "the old contents" || err != nil {
+		t.Errorf("read %q %v", got, err)
+	}
+
+	// garbage collection
+	stale := uploadID(time.Now().Add(-2 * time.Hour))
+	fresh := uploadID(time.Now())
+	for _, key := range []string{chunkKey("f", stale, 0), chunkKey("gone", stale, 0), chunkKey("f", fresh, 0), "unrelated"} {
+		c.Store(&Object{Bucket: "chunks", Key: key, Body: bytes.NewBufferString("orphan")}, nil)
+	}
+	n, err := c.CollectBlobGarbage("files", time.Hour, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 chunks to be collected; got %d", n)
+	}
+	keys, _ = c.ListBucketKeys("chunks")
+	want := append(chunkKeys(old), chunkKey("f", fresh, 0), "unrelated")
+	sort.Strings(keys)
+	sort.Strings(want)
+	if strings.Join(keys, " ") != strings.Join(want, " ") {
+		t.Errorf("expected chunks %v; got %v", want, keys)
+	}
+}
+
+func TestParseChunkKey(t *testing.T) {
+	now := time.Now()
+	key, created, ok := parseChunkKey(chunkKey("a.b.c", uploadID(now), 12))
+	if !ok || key != "a.b.c" || !created.Equal(time.Unix(0, now.UnixNano())) {
+		t.Errorf("got %q %v %v", key, created, ok)
+	}
+	for _, bad := range []string{"plain", "a.b", "a.b.c", "a.nodash.1", "a.zz!-00.1"} {
+		if _, _, ok := parseChunkKey(bad); ok {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestBlobBadManifest(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	m, err := c.PutBlob("files", "f", strings.NewReader("some contents"), "text/plain", &BlobOptions{ChunkSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := map[string]func(m *Manifest){
+		"size too large": func(m *Manifest) { m.Size++ },
+		"no chunks":      func(m *Manifest) { m.Chunks = nil },
+		"empty chunk":    func(m *Manifest) { m.Chunks = append(m.Chunks, Chunk{Key: "x"}) },
+		"negative chunk": func(m *Manifest) { m.Chunks[0].Size, m.Chunks[1].Size = -5, 15 },
+		"negative size":  func(m *Manifest) { m.Size, m.Chunks = -1, nil },
+	}
+	for name, corrupt := range manifests {
+		bad := *m
+		bad.Chunks = append([]Chunk(nil), m.Chunks...)
+		corrupt(&bad)
+		body, _ := json.Marshal(&bad)
+		c.Store(&Object{Bucket: "files", Key: "bad", Ctype: manifestType, Body: bytes.NewBuffer(body)}, nil)
+		if _, err := c.OpenBlob("files", "bad"); err != ErrCorruptManifest {
+			t.Errorf("%s: expected ErrCorruptManifest; got %v", name, err)
+		}
+	}
+	c.Store(&Object{Bucket: "files", Key: "bad", Ctype: manifestType, Body: bytes.NewBufferString("{")}, nil)
+	if _, err := c.OpenBlob("files", "bad"); err != ErrCorruptManifest {
+		t.Errorf("expected ErrCorruptManifest; got %v", err)
+	}
+
+	// objects that aren't blobs aren't overwritten
+	c.Store(&Object{Bucket: "files", Key: "plain", Ctype: "text/plain", Body: bytes.NewBufferString("precious")}, nil)
+	if _, err := c.PutBlob("files", "plain", strings.NewReader("blob"), "text/plain", nil); err != ErrNotBlob {
+		t.Errorf("expected ErrNotBlob; got %v", err)
+	}
+	if o, err := c.Fetch("files", "plain", nil); err != nil || o.Body.String() != "precious" {
+		t.Errorf("fetched %v %v", o, err)
+	}
+	if keys, _ := c.ListBucketKeys("files_chunks"); len(keys) != len(m.Chunks) {
+		t.Errorf("the refused blob's chunks weren't deleted: %v", keys)
+	}
+}
@@ -2,15 +2,35 @@ package riak
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"strconv"
 )
 
+// /types/type, or nothing for the default type
+func typePrefix(btype string) string {
+	if btype == "" {
+		return ""
+	}
+	return "/types/" + btype
+}
+
+// /buckets/bucket, or /types/type/buckets/bucket
+func bucketPath(btype string, bucket string) string {
+	return typePrefix(btype) + "/buckets/" + bucket
+}
+
 // GetBuckets gets a list of the buckets
 func (c *Client) GetBuckets() ([]string, error) {
-	res, err := c.do("GET", "/buckets?buckets=true", nil)
+	return c.GetBucketsContext(context.Background())
+}
+
+// GetBucketsContext is like GetBuckets, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) GetBucketsContext(ctx context.Context) ([]string, error) {
+	res, err := c.do(ctx, "GET", typePrefix(c.btype)+"/buckets?buckets=true", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -36,7 +56,13 @@ func (c *Client) GetBuckets() ([]string, error) {
 
 // List keys gets all the keys (note: naive)
 func (c *Client) ListBucketKeys(bucket string) ([]string, error) {
-	res, err := c.do("GET", "/buckets/"+bucket+"/keys?keys=true", nil)
+	return c.ListBucketKeysContext(context.Background(), bucket)
+}
+
+// ListBucketKeysContext is like ListBucketKeys, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) ListBucketKeysContext(ctx context.Context, bucket string) ([]string, error) {
+	res, err := c.do(ctx, "GET", bucketPath(c.btype, bucket)+"/keys?keys=true", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -58,92 +84,320 @@ func (c *Client) ListBucketKeys(bucket string) ([]string, error) {
 	return strs, nil
 }
 
-// BucketProps are the properties of a bucket
+// errNoProps is returned for unfetched props that are all
+// zero, which would otherwise silently change nothing
+var errNoProps = errors.New("riak: no bucket properties to set; name zero values in BucketProps.Zero")
+
+// BucketProps are the properties of a bucket (or bucket type).
+//
+// Props that were fetched with GetBucketProps remember their
+// fetched values, so SetBucketProps only sends the properties
+// that have changed since. For props that weren't fetched,
+// only the properties with non-zero values, and those named
+// in Zero, are sent:
+//
+//	// only changes n_val
+//	err := c.SetBucketProps("users", &BucketProps{Nval: 5})
+//
+//	// turns allow_mult off
+//	err := c.SetBucketProps("users", &BucketProps{Zero: []string{"allow_mult"}})
+//
+// Properties that this package doesn't know about are kept
+// in Extra, and are sent back to riak as they were fetched.
 type BucketProps struct {
-	Name       string `json:"name"`
-	Nval       int    `json:"n_val"`
-	Mult       bool   `json:"allow_mult"`
-	LWW        bool   `json:"last_write_wins"`
-	Precommit  []Hook `json:"precommit"`
-	Postcommit []Hook `json:"postcommit"`
-	HashKey    struct {
-		Mod string `json:"mod"`
-		Fun string `json:"fun"`
-	} `json:"chash_keyfun"`
-	Link struct {
-		Mod string `json:"mod"`
-		Fun string `json:"mapreduce_linkfun"`
-	} `json:"linkfun"`
-	OldV   int    `json:"old_vclock"`
-	YoungV int    `json:"young_vclock"`
-	BigV   int    `json:"big_vclock"`
-	SmallV int    `json:"small_vclock"`
-	R      string `json:"r"`
-	W      string `json:"w"`
-	DW     string `json:"dw"`
-}
-
-type Hook map[string]string
+	Name        string `json:"name"`
+	Nval        int    `json:"n_val"`
+	Mult        bool   `json:"allow_mult"`
+	LWW         bool   `json:"last_write_wins"`
+	Precommit   []Hook `json:"precommit"`
+	Postcommit  []Hook `json:"postcommit"`
+	HashKey     ModFun `json:"chash_keyfun"`
+	Link        ModFun `json:"linkfun"`
+	OldV        int    `json:"old_vclock"`   // seconds
+	YoungV      int    `json:"young_vclock"` // seconds
+	BigV        int    `json:"big_vclock"`
+	SmallV      int    `json:"small_vclock"`
+	PR          Quorum `json:"pr"`
+	R           Quorum `json:"r"`
+	W           Quorum `json:"w"`
+	PW          Quorum `json:"pw"`
+	DW          Quorum `json:"dw"`
+	RW          Quorum `json:"rw"`
+	BasicQuorum bool   `json:"basic_quorum"`
+	NotFoundOK  bool   `json:"notfound_ok"`
+	Backend     string `json:"backend"`
+	Search      bool   `json:"search"`       // legacy (riak_search) indexing
+	SearchIndex string `json:"search_index"` // see SetBucketSearchIndex
+	Datatype    string `json:"datatype"`     // bucket types only
+	Consistent  bool   `json:"consistent"`   // bucket types only
+	DVV         bool   `json:"dvv_enabled"`
+	WriteOnce   bool   `json:"write_once"` // bucket types only
 
-type bucketprops struct {
-	b *BucketProps `json:"props"`
+	Extra map[string]json.RawMessage `json:"-"` // unknown properties
+
+	// Zero names properties that are sent even though they are
+	// zero, for props that weren't fetched (e.g. "allow_mult")
+	Zero []string `json:"-"`
+
+	fetched map[string]json.RawMessage // for partial updates
 }
 
-func (c *Client) GetBucketProps(bucket string) (*BucketProps, error) {
-	res, err := c.do("GET", "/buckets/"+bucket+"/props", nil)
+// ModFun names an erlang function
+type ModFun struct {
+	Mod string `json:"mod"`
+	Fun string `json:"fun"`
+}
+
+// Hook is a commit hook. Erlang hooks set
+// Mod and Fun; javascript hooks set Name.
+type Hook struct {
+	Mod  string `json:"mod,omitempty"`
+	Fun  string `json:"fun,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Quorum is a quorum property: either a
+// number, or one of "one", "quorum", "all"
+// or "default".
+type Quorum string
+
+// MarshalJSON writes numeric quorums as numbers
+func (q Quorum) MarshalJSON() ([]byte, error) {
+	if _, err := strconv.ParseUint(string(q), 10, 32); err == nil {
+		return []byte(q), nil
+	}
+	return json.Marshal(string(q))
+}
+
+// UnmarshalJSON accepts numbers or strings
+func (q *Quorum) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*q = Quorum(s)
+		return nil
+	}
+	var n uint64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*q = Quorum(strconv.FormatUint(n, 10))
+	return nil
+}
+
+// so that the methods below can use
+// the default encoding of the fields
+type rawBucketProps BucketProps
+
+// MarshalJSON returns every property, including Extra
+func (p BucketProps) MarshalJSON() ([]byte, error) {
+	fields, err := p.fields()
 	if err != nil {
 		return nil, err
 	}
+	return json.Marshal(fields)
+}
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("Status Code %d", res.StatusCode)
+// UnmarshalJSON sets the known properties, and
+// keeps the rest in Extra
+func (p *BucketProps) UnmarshalJSON(b []byte) error {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, (*rawBucketProps)(p)); err != nil {
+		return err
+	}
+	p.Extra = nil
+	for name, v := range all {
+		if _, ok := zeroProps[name]; ok {
+			continue
+		}
+		if p.Extra == nil {
+			p.Extra = make(map[string]json.RawMessage)
+		}
+		p.Extra[name] = v
 	}
+	return nil
+}
 
-	bckts := new(bucketprops)
-	dec := json.NewDecoder(res.Body)
-	err = dec.Decode(bckts)
-	return bckts.b, err
+// the encoding of each property
+func (p *BucketProps) fields() (map[string]json.RawMessage, error) {
+	b, err := json.Marshal((*rawBucketProps)(p))
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	// riak clears hooks with [], not null
+	for _, name := range []string{"precommit", "postcommit"} {
+		if string(fields[name]) == "null" {
+			fields[name] = json.RawMessage("[]")
+		}
+	}
+	for name, v := range p.Extra {
+		if _, ok := fields[name]; !ok {
+			fields[name] = v
+		}
+	}
+	return fields, nil
+}
+
+// the encoding of every known property's zero value
+var zeroProps map[string]json.RawMessage
+
+func init() {
+	zeroProps, _ = new(BucketProps).fields()
+}
+
+// changes returns the properties that differ from the
+// fetched ones, or the non-zero ones (and those named in
+// Zero) if 'p' wasn't fetched
+func (p *BucketProps) changes() (map[string]json.RawMessage, error) {
+	fields, err := p.fields()
+	if err != nil {
+		return nil, err
+	}
+	base := p.fetched
+	zero := make(map[string]bool)
+	if base == nil {
+		base = zeroProps
+		for _, name := range p.Zero {
+			if _, ok := zeroProps[name]; !ok {
+				return nil, fmt.Errorf("riak: unknown bucket property %q", name)
+			}
+			zero[name] = true
+		}
+	}
+	out := make(map[string]json.RawMessage)
+	for name, v := range fields {
+		if name == "name" {
+			continue
+		}
+		if old, ok := base[name]; ok && bytes.Equal(old, v) && !zero[name] {
+			continue
+		}
+		out[name] = v
+	}
+	return out, nil
+}
+
+// the body of props requests and responses
+type bucketprops struct {
+	Props interface{} `json:"props"`
+}
+
+// GetBucketProps gets the properties of a bucket
+func (c *Client) GetBucketProps(bucket string) (*BucketProps, error) {
+	return c.GetBucketPropsContext(context.Background(), bucket)
 }
 
+// GetBucketPropsContext is like GetBucketProps, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) GetBucketPropsContext(ctx context.Context, bucket string) (*BucketProps, error) {
+	return c.getProps(ctx, bucketPath(c.btype, bucket)+"/props")
+}
+
+func (c *Client) getProps(ctx context.Context, path string) (*BucketProps, error) {
+	res, err := c.do(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := streamStatus(res); err != nil {
+		return nil, err
+	}
+	props := new(BucketProps)
+	err = json.NewDecoder(res.Body).Decode(&bucketprops{Props: props})
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// normalized, so that changes can be detected
+	props.fetched, err = props.fields()
+	if err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// SetBucketProps sets the properties of a bucket. Only the
+// properties that have changed are sent (see BucketProps).
+// If nothing has changed, no request is made; props that
+// weren't fetched and have nothing to send are an error.
 func (c *Client) SetBucketProps(bucket string, props *BucketProps) error {
-	buf := bytes.NewBuffer(nil)
-	enc := json.NewEncoder(buf)
-	err := enc.Encode(bucketprops{b: props})
+	return c.SetBucketPropsContext(context.Background(), bucket, props)
+}
+
+// SetBucketPropsContext is like SetBucketProps, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) SetBucketPropsContext(ctx context.Context, bucket string, props *BucketProps) error {
+	return c.setProps(ctx, bucketPath(c.btype, bucket)+"/props", props)
+}
+
+func (c *Client) setProps(ctx context.Context, path string, props *BucketProps) error {
+	changes, err := props.changes()
 	if err != nil {
 		return err
 	}
+	if len(changes) == 0 {
+		if props.fetched == nil {
+			return errNoProps
+		}
+		return nil
+	}
+	if err := c.putProps(ctx, path, changes); err != nil {
+		return err
+	}
+	// later sets only send later changes
+	props.fetched, err = props.fields()
+	return err
+}
 
-	r, err := http.NewRequest("PUT", c.host+"/buckets/"+bucket+"/props", buf)
+// PUT exactly 'changes'
+func (c *Client) putProps(ctx context.Context, path string, changes map[string]json.RawMessage) error {
+	body, err := json.Marshal(bucketprops{Props: changes})
 	if err != nil {
 		return err
 	}
 
-	r.Header.Set("Content-Type", "application/json")
-	res, err := c.cl.Do(r)
-	if res.Body != nil {
-		res.Body.Close()
+	r, err := c.newreq(ctx, "PUT", path, bytes.NewReader(body))
+	if err != nil {
+		return err
 	}
+
+	r.Header.Set("Content-Type", "application/json")
+	res, err := c.send(r)
 	if err != nil {
 		return err
 	}
+	res.Body.Close()
 	switch res.StatusCode {
 	//success
 	case 204:
 		return nil
 		//otherwise
+	case 400:
+		return ErrBadRequest
 	default:
 		return statusCode(res.StatusCode)
 	}
 }
 
+// ResetBucketProps resets the properties of a
+// bucket to the defaults of its bucket type
 func (c *Client) ResetBucketProps(bucket string) error {
-	res, err := c.do("DELETE", "/buckets/"+bucket+"/props", nil)
-	if res.Body != nil {
-		res.Body.Close()
-	}
+	return c.ResetBucketPropsContext(context.Background(), bucket)
+}
+
+// ResetBucketPropsContext is like ResetBucketProps, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) ResetBucketPropsContext(ctx context.Context, bucket string) error {
+	res, err := c.do(ctx, "DELETE", bucketPath(c.btype, bucket)+"/props", nil)
 	if err != nil {
 		return err
 	}
+	res.Body.Close()
 	switch res.StatusCode {
 	case 204:
 		return nil
@@ -151,3 +405,34 @@ func (c *Client) ResetBucketProps(bucket string) error {
 		return statusCode(res.StatusCode)
 	}
 }
+
+// GetBucketTypeProps gets the properties of the bucket type
+// 'name', which are the defaults for buckets of that type.
+func (c *Client) GetBucketTypeProps(name string) (*BucketProps, error) {
+	return c.GetBucketTypePropsContext(context.Background(), name)
+}
+
+// GetBucketTypePropsContext is like GetBucketTypeProps, but the
+// request is abandoned if 'ctx' is done before it completes.
+func (c *Client) GetBucketTypePropsContext(ctx context.Context, name string) (*BucketProps, error) {
+	if name == "" {
+		return nil, errors.New("riak: empty bucket type name")
+	}
+	return c.getProps(ctx, typePrefix(name)+"/props")
+}
+
+// SetBucketTypeProps sets the properties of the bucket type
+// 'name'. Some properties, such as datatype, can't be changed
+// once the type has been activated.
+func (c *Client) SetBucketTypeProps(name string, props *BucketProps) error {
+	return c.SetBucketTypePropsContext(context.Background(), name, props)
+}
+
+// SetBucketTypePropsContext is like SetBucketTypeProps, but the
+// request is abandoned if 'ctx' is done before it completes.
+func (c *Client) SetBucketTypePropsContext(ctx context.Context, name string, props *BucketProps) error {
+	if name == "" {
+		return errors.New("riak: empty bucket type name")
+	}
+	return c.setProps(ctx, typePrefix(name)+"/props", props)
+}
@@ -0,0 +1,136 @@
+package riak
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBucketTypePaths(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "POST":
+			w.Header().Set("Location", r.URL.Path+"/newkey")
+			w.WriteHeader(201)
+		case r.Method == "PUT" || r.Method == "DELETE":
+			w.WriteHeader(204)
+		case strings.HasSuffix(r.URL.Path, "/props"):
+			io.WriteString(w, `{"props":{"n_val":3}}`)
+		case strings.Contains(r.URL.Path, "/index/"):
+			io.WriteString(w, `{"keys":["alice"]}`)
+		case strings.HasSuffix(r.URL.Path, "/buckets"):
+			io.WriteString(w, `{"buckets":["eu"]}`)
+		case strings.HasSuffix(r.URL.Path, "/keys"):
+			io.WriteString(w, `{"keys":["alice"]}`)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "hello")
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	users := c.BucketType("users")
+
+	o, err := users.Fetch("eu", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.BucketType != "users" {
+		t.Errorf("fetched object has bucket type %q", o.BucketType)
+	}
+	// the object remembers its type, even
+	// when it's stored with an untyped client
+	if err := c.Store(o, nil); err != nil {
+		t.Fatal(err)
+	}
+	bob := &Object{Bucket: "eu", Key: "bob"}
+	if err := users.Delete(bob, nil); err != nil {
+		t.Fatal(err)
+	}
+	if bob.BucketType != "" {
+		t.Errorf("Delete set the object's bucket type to %q", bob.BucketType)
+	}
+	created := &Object{Bucket: "eu", Body: bytes.NewBufferString("new")}
+	if err := users.CreateObject(created, nil); err != nil {
+		t.Fatal(err)
+	}
+	if created.Key != "newkey" || created.BucketType != "users" {
+		t.Errorf("created %s (type %q)", created.Key, created.BucketType)
+	}
+	if _, err := users.GetBuckets(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.ListBucketKeys("eu"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Query(NewIndexQuery("eu", "age_int").Range("18", "65")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.GetBucketProps("eu"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.ResetBucketProps("eu"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBucketTypeProps("users"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBucketTypeProps("users", &BucketProps{Nval: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBucketTypeProps(""); err == nil {
+		t.Error("expected an error for an empty bucket type")
+	}
+
+	// the default type is unchanged
+	if _, err := c.Fetch("eu", "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListBucketKeys("eu"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET /types/users/buckets/eu/keys/alice",
+		"PUT /types/users/buckets/eu/keys/alice",
+		"DELETE /types/users/buckets/eu/keys/bob",
+		"POST /types/users/buckets/eu/keys",
+		"GET /types/users/buckets",
+		"GET /types/users/buckets/eu/keys",
+		"GET /types/users/buckets/eu/index/age_int/18/65",
+		"GET /types/users/buckets/eu/props",
+		"DELETE /types/users/buckets/eu/props",
+		"GET /types/users/props",
+		"PUT /types/users/props",
+		"GET /riak/eu/alice",
+		"GET /buckets/eu/keys",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("expected requests\n%q\ngot\n%q", want, paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("request %d: expected %q; got %q", i, want[i], paths[i])
+		}
+	}
+}
+
+func TestBucketTypeRoutes(t *testing.T) {
+	rt, ok := parseRoute("/types/users/buckets/eu/keys/alice")
+	if !ok || rt.kind != routeObject || rt.btype != "users" || rt.bucket != "eu" || rt.key != "alice" {
+		t.Errorf("bad object route %+v", rt)
+	}
+	rt, ok = parseRoute("/types/users/props")
+	if !ok || rt.kind != routeTypeProps || rt.btype != "users" {
+		t.Errorf("bad type props route %+v", rt)
+	}
+	if _, ok := parseRoute("/props"); ok {
+		t.Error("untyped /props shouldn't parse")
+	}
+}
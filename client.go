@@ -1,23 +1,58 @@
 package riak
 
 import (
+	"context"
 	"io"
 	"net/http"
 )
 
-func NewClient(host string, clientID string) *Client {
-	return &Client{
+// NewClient returns a client that talks to the
+// riak node at 'host' (e.g. "http://localhost:8098").
+func NewClient(host string, clientID string, opts ...Option) *Client {
+	c := &Client{
 		cl:   &http.Client{},
 		host: host,
 		id:   clientID,
 	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
 }
 
-// FOR TESTING
-type doer interface {
+// Option configures optional Client behavior.
+type Option func(*Client)
+
+// Transport sends the HTTP requests that a Client builds.
+// *http.Client is a Transport, as are the transports that
+// NewClusterClient and Protobuf configure. A Transport may
+// wrap another one in order to observe or change requests,
+// e.g. to record them.
+type Transport interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
+// WithTransport makes the client send its
+// requests with 't' instead of an *http.Client.
+// If 't' is an io.Closer, Close closes it.
+func WithTransport(t Transport) Option {
+	return func(c *Client) { c.cl, c.base = t, t }
+}
+
+// WrapTransport replaces the client's transport with
+// 'wrap' of it. Unlike WithTransport, it works with the
+// transports configured by NewClusterClient and Protobuf,
+// as long as it comes after Protobuf in the option list.
+// Nodes and Close keep working with the wrapped transport,
+// and wrappers aren't closed by Close.
+//
+//	c, err := NewClusterClient(nodes, id, nil, WrapTransport(func(t Transport) Transport {
+//		return &loggingTransport{t}
+//	}))
+func WrapTransport(wrap func(Transport) Transport) Option {
+	return func(c *Client) { c.cl = wrap(c.cl) }
+}
+
 // FOR TESTING
 type testDo struct {
 	client  *http.Client
@@ -56,17 +91,123 @@ func (c *Client) lastres() *http.Response {
 }
 
 type Client struct {
-	cl   doer
-	host string
-	id   string
+	cl        Transport
+	base      Transport // 'cl' before WrapTransport; see Nodes and Close
+	host      string
+	id        string
+	retry     *RetryPolicy
+	resolvers map[resolverKey]Resolver // by bucket type and bucket
+	ctype     string                   // for StoreValue
+	btype     string                   // bucket type; "" is the default
+	prefixes  map[string]string        // see UseResources
+}
+
+// BucketType returns a client that addresses buckets of
+// the bucket type 'name' instead of the default type. It
+// shares the connections and options of 'c'. Objects that
+// it fetches or creates have their BucketType set; other
+// objects passed to it without a BucketType are addressed
+// in 'name', but aren't modified.
+//
+//	users := c.BucketType("users")
+//	o, err := users.Fetch("eu", "alice", nil) // /types/users/buckets/eu/keys/alice
+func (c *Client) BucketType(name string) *Client {
+	tc := *c
+	tc.btype = name
+	return &tc
+}
+
+// the object's path, in the client's bucket
+// type if the object doesn't have one
+func (c *Client) objpath(o *Object) string {
+	btype := o.BucketType
+	if btype == "" {
+		btype = c.btype
+	}
+	return objectPath(btype, o.Bucket, o.Key)
+}
+
+// Nodes returns the nodes that the client
+// distributes requests across, or nil if
+// the client only talks to a single host.
+func (c *Client) Nodes() []*Node {
+	if cl, ok := c.base.(*cluster); ok {
+		return append([]*Node(nil), cl.nodes...)
+	}
+	return nil
+}
+
+// Close releases any resources held by
+// the client, such as background health checks.
+func (c *Client) Close() error {
+	if cl, ok := c.base.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
 }
 
 // only for bucket props, etc.
-func (c *Client) do(method string, path string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, c.host+path, body)
+func (c *Client) do(ctx context.Context, method string, path string, body io.Reader) (*http.Response, error) {
+	req, err := c.newreq(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(req)
+}
+
+// newreq creates a request for 'path' on this client's host
+func (c *Client) newreq(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.host+c.rewrite(path), body)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("X-Riak-ClientId", c.id)
+	return req, nil
+}
+
+// send sends a request, retrying it according to the
+// client's RetryPolicy if the request is idempotent.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	return c.sendRetry(req, idempotent(req.Method))
+}
+
+// sendOnce sends a request. If the request's context is done,
+// the context's error is returned instead of the transport
+// error so that callers can tell cancellation apart from
+// riak errors. The response body is wrapped so that reads
+// also fail with the context's error.
+func (c *Client) sendOnce(req *http.Request) (*http.Response, error) {
 	res, err := c.cl.Do(req)
-	return res, err
+	if err != nil {
+		if cerr := req.Context().Err(); cerr != nil {
+			return nil, cerr
+		}
+		return nil, err
+	}
+	if ctx := req.Context(); ctx.Done() != nil {
+		res.Body = &ctxReader{ctx: ctx, rc: res.Body}
+	}
+	return res, nil
+}
+
+// ctxReader is a body that returns
+// the context's error once it is done
+type ctxReader struct {
+	ctx context.Context
+	rc  io.ReadCloser
 }
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.rc.Read(p)
+	if err != nil && err != io.EOF {
+		if cerr := r.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+func (r *ctxReader) Close() error { return r.rc.Close() }
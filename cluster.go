@@ -0,0 +1,261 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Node is a single member of a cluster of riak nodes.
+// Nodes are handed to a Balancer so that it can
+// choose where the next request should go.
+type Node struct {
+	addr    *url.URL
+	pending int64 // requests in flight
+	down    int32 // 1 if unhealthy
+}
+
+// Addr returns the base URL of the node
+func (n *Node) Addr() string { return n.addr.String() }
+
+// Pending returns the number of requests
+// currently outstanding against the node.
+func (n *Node) Pending() int { return int(atomic.LoadInt64(&n.pending)) }
+
+// Healthy returns whether or not the node
+// is currently considered healthy.
+func (n *Node) Healthy() bool { return atomic.LoadInt32(&n.down) == 0 }
+
+func (n *Node) markDown() { atomic.StoreInt32(&n.down, 1) }
+
+func (n *Node) markUp() { atomic.StoreInt32(&n.down, 0) }
+
+// Balancer chooses one node out of a list
+// of healthy nodes. 'nodes' is never empty.
+// Balancers must be safe for concurrent use.
+type Balancer interface {
+	Pick(nodes []*Node) *Node
+}
+
+type roundRobin struct {
+	next uint32
+}
+
+func (r *roundRobin) Pick(nodes []*Node) *Node {
+	i := atomic.AddUint32(&r.next, 1)
+	return nodes[int(i-1)%len(nodes)]
+}
+
+// RoundRobin returns a Balancer that cycles through
+// the available nodes in order.
+func RoundRobin() Balancer { return new(roundRobin) }
+
+type leastOutstanding struct{}
+
+func (leastOutstanding) Pick(nodes []*Node) *Node {
+	best := nodes[0]
+	for _, n := range nodes[1:] {
+		if n.Pending() < best.Pending() {
+			best = n
+		}
+	}
+	return best
+}
+
+// LeastOutstanding returns a Balancer that picks the
+// node with the fewest requests in flight.
+func LeastOutstanding() Balancer { return leastOutstanding{} }
+
+type random struct {
+	lock sync.Mutex
+	rnd  *rand.Rand
+}
+
+func (r *random) Pick(nodes []*Node) *Node {
+	r.lock.Lock()
+	i := r.rnd.Intn(len(nodes))
+	r.lock.Unlock()
+	return nodes[i]
+}
+
+// Random returns a Balancer that picks
+// a node uniformly at random.
+func Random() Balancer {
+	return &random{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// ClusterOptions configure a cluster client.
+// The zero value is a valid configuration.
+type ClusterOptions struct {
+	Balancer      Balancer      // defaults to RoundRobin()
+	ProbeInterval time.Duration // how often unhealthy nodes are pinged; defaults to 5s
+	HTTPClient    *http.Client  // defaults to a new http.Client
+}
+
+// cluster is a Transport that spreads requests
+// across a number of nodes
+type cluster struct {
+	client   *http.Client
+	nodes    []*Node
+	balancer Balancer
+	interval time.Duration
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewClusterClient returns a client that distributes requests
+// across the nodes at the given URLs (e.g. "http://10.0.0.1:8098").
+// Nodes that return transport errors or 5xx status codes are
+// marked unhealthy and are skipped until they respond to a ping again.
+// If every node is unhealthy, requests are sent to all of them anyway.
+// Call Close on the client to stop the background health checks.
+func NewClusterClient(nodes []string, clientID string, opts *ClusterOptions, copts ...Option) (*Client, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("riak: no nodes specified")
+	}
+	if opts == nil {
+		opts = &ClusterOptions{}
+	}
+	cl := &cluster{
+		client:   opts.HTTPClient,
+		balancer: opts.Balancer,
+		interval: opts.ProbeInterval,
+		done:     make(chan struct{}),
+	}
+	if cl.client == nil {
+		cl.client = &http.Client{}
+	}
+	if cl.balancer == nil {
+		cl.balancer = RoundRobin()
+	}
+	if cl.interval <= 0 {
+		cl.interval = 5 * time.Second
+	}
+	for _, addr := range nodes {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("riak: node address must be an absolute URL: " + addr)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		cl.nodes = append(cl.nodes, &Node{addr: u})
+	}
+	cl.wg.Add(1)
+	go cl.probe()
+	c := &Client{
+		cl:   cl,
+		base: cl,
+		id:   clientID,
+	}
+	for _, o := range copts {
+		o(c)
+	}
+	return c, nil
+}
+
+func (c *cluster) pick() *Node {
+	var stack [16]*Node
+	healthy := stack[0:0]
+	for _, n := range c.nodes {
+		if n.Healthy() {
+			healthy = append(healthy, n)
+		}
+	}
+	if len(healthy) == 0 {
+		return c.balancer.Pick(c.nodes)
+	}
+	return c.balancer.Pick(healthy)
+}
+
+// Do sends the request to the node chosen by the balancer.
+// The request's URL only needs to contain a path.
+func (c *cluster) Do(req *http.Request) (*http.Response, error) {
+	n := c.pick()
+	r := req.Clone(req.Context())
+	r.URL.Scheme = n.addr.Scheme
+	r.URL.Host = n.addr.Host
+	r.URL.Path = n.addr.Path + r.URL.Path
+	r.Host = n.addr.Host
+
+	atomic.AddInt64(&n.pending, 1)
+	res, err := c.client.Do(r)
+	if err != nil {
+		atomic.AddInt64(&n.pending, -1)
+		n.markDown()
+		return nil, err
+	}
+	if res.StatusCode >= 500 {
+		n.markDown()
+	}
+	// the request is pending until its body has been read
+	res.Body = &pendingBody{ReadCloser: res.Body, n: n}
+	return res, nil
+}
+
+// pendingBody decrements its node's pending
+// requests when it's closed
+type pendingBody struct {
+	io.ReadCloser
+	n    *Node
+	once sync.Once
+}
+
+func (p *pendingBody) Close() error {
+	p.once.Do(func() { atomic.AddInt64(&p.n.pending, -1) })
+	return p.ReadCloser.Close()
+}
+
+// ping the unhealthy nodes every interval
+func (c *cluster) probe() {
+	defer c.wg.Done()
+	tick := time.NewTicker(c.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tick.C:
+		}
+		for _, n := range c.nodes {
+			if n.Healthy() {
+				continue
+			}
+			if c.ping(n) {
+				n.markUp()
+			}
+		}
+	}
+}
+
+func (c *cluster) ping(n *Node) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", n.addr.String()+"/ping", nil)
+	if err != nil {
+		return false
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res.StatusCode == 200
+}
+
+// Close stops the health checks
+func (c *cluster) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.wg.Wait()
+	return nil
+}
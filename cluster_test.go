@@ -0,0 +1,133 @@
+package riak
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoundRobin(t *testing.T) {
+	nodes := []*Node{{}, {}, {}}
+	b := RoundRobin()
+	for i := 0; i < 6; i++ {
+		if n := b.Pick(nodes); n != nodes[i%3] {
+			t.Fatalf("pick %d: got node %p; expected %p", i, n, nodes[i%3])
+		}
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	nodes := []*Node{{pending: 3}, {pending: 1}, {pending: 2}}
+	if n := LeastOutstanding().Pick(nodes); n != nodes[1] {
+		t.Fatalf("expected the node with 1 pending request; got %d", n.Pending())
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	var bad, good int32
+	var broken int32 = 1
+	badsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&bad, 1)
+		if r.URL.Path == "/ping" && atomic.LoadInt32(&broken) == 0 {
+			w.WriteHeader(200)
+			return
+		}
+		w.WriteHeader(500)
+	}))
+	defer badsrv.Close()
+	goodsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&good, 1)
+		w.WriteHeader(404)
+	}))
+	defer goodsrv.Close()
+
+	c, err := NewClusterClient([]string{badsrv.URL, goodsrv.URL}, "testClient", &ClusterOptions{
+		ProbeInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the first request goes to the bad node, which is then
+	// taken out of rotation
+	_, err = c.Fetch("testing", "key", nil)
+	if _, ok := err.(ErrStatusCode); !ok {
+		t.Fatalf("expected a status code error; got %v", err)
+	}
+	if c.Nodes()[0].Healthy() {
+		t.Fatal("expected the first node to be marked unhealthy")
+	}
+	for i := 0; i < 4; i++ {
+		_, err = c.Fetch("testing", "key", nil)
+		if err != ErrNotFound {
+			t.Fatalf("expected ErrNotFound; got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&good); n != 4 {
+		t.Errorf("expected 4 requests to the healthy node; got %d", n)
+	}
+
+	// once the node responds to pings, it
+	// should be put back into rotation
+	atomic.StoreInt32(&broken, 0)
+	deadline := time.Now().Add(time.Second)
+	for !c.Nodes()[0].Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("node was never marked healthy again")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClusterPending(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+	c, err := NewClusterClient([]string{srv.URL}, "testClient", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	res, err := c.do(context.Background(), "GET", "/riak/b/k", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := c.Nodes()[0]
+	if n.Pending() != 1 {
+		t.Errorf("expected 1 pending request while the body is unread; got %d", n.Pending())
+	}
+	res.Body.Close()
+	res.Body.Close()
+	if n.Pending() != 0 {
+		t.Errorf("expected no pending requests; got %d", n.Pending())
+	}
+
+	// callers can't modify the client's nodes
+	c.Nodes()[0] = nil
+	if c.Nodes()[0] != n {
+		t.Error("Nodes returned the client's own slice")
+	}
+}
+
+func TestClusterWrapped(t *testing.T) {
+	c, err := NewClusterClient([]string{"http://127.0.0.1:1"}, "testClient", nil,
+		WrapTransport(func(tr Transport) Transport { return transportFunc(tr.Do) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Nodes()) != 1 {
+		t.Errorf("expected the wrapped cluster's nodes; got %v", c.Nodes())
+	}
+	c.Close()
+	select {
+	case <-c.base.(*cluster).done:
+	default:
+		t.Error("Close didn't stop the wrapped cluster's health checks")
+	}
+}
@@ -0,0 +1,180 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Codec marshals and unmarshals object
+// bodies of a particular content type.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// JSON and gob are supported out of the box
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: map[string]Codec{
+	"application/json":  jsonCodec{},
+	"application/x-gob": gobCodec{},
+}}
+
+// RegisterCodec registers a Codec for a content type
+// (e.g. "application/x-msgpack"), replacing any codec
+// previously registered for it. Parameters such as
+// charset are ignored when content types are matched.
+func RegisterCodec(ctype string, c Codec) {
+	codecs.Lock()
+	codecs.m[mediaType(ctype)] = c
+	codecs.Unlock()
+}
+
+// ErrNoCodec is returned when there is no
+// Codec registered for a content type.
+type ErrNoCodec struct {
+	Ctype string
+}
+
+func (e *ErrNoCodec) Error() string {
+	return "riak: no codec registered for content type " + e.Ctype
+}
+
+func mediaType(ctype string) string {
+	mt, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(ctype))
+	}
+	return mt
+}
+
+func codecFor(ctype string) (Codec, error) {
+	codecs.RLock()
+	c, ok := codecs.m[mediaType(ctype)]
+	codecs.RUnlock()
+	if !ok {
+		return nil, &ErrNoCodec{Ctype: ctype}
+	}
+	return c, nil
+}
+
+// Encode sets the object's body to 'v' marshalled
+// with the codec for 'ctype', and sets its content type.
+func (o *Object) Encode(ctype string, v interface{}) error {
+	c, err := codecFor(ctype)
+	if err != nil {
+		return err
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if o.Body == nil {
+		o.Body = bytes.NewBuffer(data)
+	} else {
+		o.Body.Reset()
+		o.Body.Write(data)
+	}
+	o.Ctype = ctype
+	return nil
+}
+
+// Decode unmarshals the object's body into 'v'
+// using the codec for the object's content type.
+func (o *Object) Decode(v interface{}) error {
+	c, err := codecFor(o.Ctype)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if o.Body != nil {
+		data = o.Body.Bytes()
+	}
+	return c.Unmarshal(data, v)
+}
+
+// WithContentType sets the content type that StoreValue
+// uses to encode values. The default is "application/json".
+func WithContentType(ctype string) Option {
+	return func(c *Client) { c.ctype = ctype }
+}
+
+// FetchInto fetches the object at bucket/key and decodes its
+// body into 'v' according to its content type. Valid options
+// are the same as for Fetch. If 'v' points to a struct, its
+// tagged fields are then set as described for ReadFields.
+func (c *Client) FetchInto(bucket string, key string, v interface{}, opts map[string]string) error {
+	return c.FetchIntoContext(context.Background(), bucket, key, v, opts)
+}
+
+// FetchIntoContext is like FetchInto, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FetchIntoContext(ctx context.Context, bucket string, key string, v interface{}, opts map[string]string) error {
+	o, err := c.FetchContext(ctx, bucket, key, opts)
+	if err != nil {
+		return err
+	}
+	err = o.Decode(v)
+	if err == nil && isStruct(v) {
+		err = o.ReadFields(v)
+	}
+	Release(o)
+	return err
+}
+
+// StoreValue encodes 'v' with the client's content type (see
+// WithContentType) and stores it at bucket/key. Like Store,
+// it doesn't do if-not-modified checks. Valid options are the
+// same as for Store. If 'v' is a struct, its tagged fields are
+// copied onto the object as described for WriteFields, and
+// 'key' may be empty if the struct has a key field.
+func (c *Client) StoreValue(bucket string, key string, v interface{}, opts map[string]string) error {
+	return c.StoreValueContext(context.Background(), bucket, key, v, opts)
+}
+
+// StoreValueContext is like StoreValue, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) StoreValueContext(ctx context.Context, bucket string, key string, v interface{}, opts map[string]string) error {
+	ctype := c.ctype
+	if ctype == "" {
+		ctype = "application/json"
+	}
+	o := newObj()
+	o.Bucket, o.Key = bucket, key
+	err := o.Encode(ctype, v)
+	if err == nil && isStruct(v) {
+		err = o.WriteFields(v)
+	}
+	if err == nil && o.Key == "" {
+		err = errors.New("riak: StoreValue needs a key")
+	}
+	if err == nil {
+		err = c.StoreContext(ctx, o, opts)
+	}
+	Release(o)
+	return err
+}
@@ -0,0 +1,89 @@
+package riak
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testValue struct {
+	Name  string
+	Count int
+}
+
+// a codec that stores values as "name:count"
+type colonCodec struct{}
+
+func (colonCodec) Marshal(v interface{}) ([]byte, error) {
+	tv := v.(*testValue)
+	return []byte(tv.Name + ":" + strings.Repeat("x", tv.Count)), nil
+}
+
+func (colonCodec) Unmarshal(data []byte, v interface{}) error {
+	parts := strings.SplitN(string(data), ":", 2)
+	tv := v.(*testValue)
+	tv.Name, tv.Count = parts[0], len(parts[1])
+	return nil
+}
+
+func TestObjectCodecs(t *testing.T) {
+	RegisterCodec("application/x-colon", colonCodec{})
+	in := &testValue{Name: "bob", Count: 3}
+	for _, ctype := range []string{"application/json", "application/x-gob", "application/x-colon"} {
+		o := &Object{}
+		if err := o.Encode(ctype, in); err != nil {
+			t.Fatalf("%s: %s", ctype, err)
+		}
+		if o.Ctype != ctype {
+			t.Errorf("expected content type %q; got %q", ctype, o.Ctype)
+		}
+		var out testValue
+		if err := o.Decode(&out); err != nil {
+			t.Fatalf("%s: %s", ctype, err)
+		}
+		if out != *in {
+			t.Errorf("%s: expected %+v; got %+v", ctype, *in, out)
+		}
+	}
+
+	o := &Object{Ctype: "application/x-unknown", Body: bytes.NewBufferString("???")}
+	var out testValue
+	if _, ok := o.Decode(&out).(*ErrNoCodec); !ok {
+		t.Error("expected *ErrNoCodec for an unregistered content type")
+	}
+}
+
+func TestStoreValueFetchInto(t *testing.T) {
+	var stored []byte
+	var ctype string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			stored, _ = io.ReadAll(r.Body)
+			ctype = r.Header.Get("Content-Type")
+			w.WriteHeader(204)
+			return
+		}
+		w.Header().Set("Content-Type", ctype+"; charset=utf-8")
+		w.Write(stored)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	in := &testValue{Name: "alice", Count: 7}
+	if err := c.StoreValue("testing", "value", in, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ctype != "application/json" {
+		t.Errorf("expected content type %q; got %q", "application/json", ctype)
+	}
+	var out testValue
+	if err := c.FetchInto("testing", "value", &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out != *in {
+		t.Errorf("expected %+v; got %+v", *in, out)
+	}
+}
@@ -1,5 +1,3 @@
-// +build riak
-
 package riak
 
 import (
@@ -16,7 +14,7 @@ func TestVclockCollision(t *testing.T) {
 	errB := <-errChan
 
 	if (errA == nil) && (errB == nil) {
-		c := newtestclient("http://localhost:8098")
+		c := newtestclient(testHost)
 		_, err := c.Fetch("collision", "myKey", nil)
 		_, ok := err.(*ErrMultipleVclocks)
 		if !ok {
@@ -39,7 +37,7 @@ func makeObj(bucket string, key string, bodyS string, errChan chan error) {
 		Bucket: bucket,
 		Body:   &body,
 	}
-	c := newtestclient("http://localhost:8098")
+	c := newtestclient(testHost)
 	c.id = bodyS
 	err := c.Store(obj, nil)
 	errChan <- err
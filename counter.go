@@ -0,0 +1,116 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrCounterAllowMult is returned by the counter methods
+// when the bucket doesn't have allow_mult set, which riak
+// requires in order to store counters.
+var ErrCounterAllowMult = errors.New("riak: counters require allow_mult=true on the bucket (409)")
+
+// [/types/type]/buckets/bucket/counters/key?opts
+func counterPath(btype string, bucket string, key string, opts map[string]string) string {
+	path := bucketPath(btype, bucket) + "/counters/" + key
+	if len(opts) > 0 {
+		query := make(url.Values)
+		for k, v := range opts {
+			query.Set(k, v)
+		}
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+// IncrementCounter adds 'delta' (which may be negative) to the
+// counter at bucket/key. Counters that don't exist start at zero.
+// If 'opts' sets "returnvalue" to "true", the counter's new value
+// is returned; otherwise the value is zero. Valid options are:
+// - 'returnvalue':(true/false) - return the new value
+// - 'w' - write quorum (number, 'quorum', or 'all')
+// - 'dw' - durable write quorum (number, 'quorum', or 'all')
+// - 'pw' - primary replicas (number, 'quorum', or 'all')
+func (c *Client) IncrementCounter(bucket string, key string, delta int64, opts map[string]string) (int64, error) {
+	return c.IncrementCounterContext(context.Background(), bucket, key, delta, opts)
+}
+
+// IncrementCounterContext is like IncrementCounter, but the request
+// is abandoned if 'ctx' is done before it completes.
+func (c *Client) IncrementCounterContext(ctx context.Context, bucket string, key string, delta int64, opts map[string]string) (int64, error) {
+	body := strings.NewReader(strconv.FormatInt(delta, 10))
+	req, err := c.newreq(ctx, "POST", counterPath(c.btype, bucket, key, opts), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	// increments aren't idempotent
+	res, err := c.sendRetry(req, c.retry != nil && c.retry.RetryUpdates)
+	if err != nil {
+		return 0, err
+	}
+	switch res.StatusCode {
+	case 204:
+		res.Body.Close()
+		return 0, nil
+	case 200:
+		return counterValue(res.Body)
+	default:
+		return 0, counterStatus(res.StatusCode, res.Body)
+	}
+}
+
+// GetCounter gets the value of the counter at bucket/key.
+// Counters that have never been incremented are ErrNotFound.
+// Valid options are:
+// - 'r':(number) (read quorum)
+// - 'pr':(number) (primary replicas)
+// - 'basic_quorum':(true/false)
+// - 'notfound_ok':(true/false)
+func (c *Client) GetCounter(bucket string, key string, opts map[string]string) (int64, error) {
+	return c.GetCounterContext(context.Background(), bucket, key, opts)
+}
+
+// GetCounterContext is like GetCounter, but the request
+// is abandoned if 'ctx' is done before it completes.
+func (c *Client) GetCounterContext(ctx context.Context, bucket string, key string, opts map[string]string) (int64, error) {
+	res, err := c.do(ctx, "GET", counterPath(c.btype, bucket, key, opts), nil)
+	if err != nil {
+		return 0, err
+	}
+	if res.StatusCode != 200 {
+		return 0, counterStatus(res.StatusCode, res.Body)
+	}
+	return counterValue(res.Body)
+}
+
+func counterValue(body io.ReadCloser) (int64, error) {
+	p, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(p)), 10, 64)
+}
+
+// closes 'body'
+func counterStatus(code int, body io.ReadCloser) error {
+	msg, _ := io.ReadAll(body)
+	body.Close()
+	switch {
+	case code == 409 || strings.Contains(string(msg), "allow_mult"):
+		return ErrCounterAllowMult
+	case code == 400:
+		return ErrBadRequest
+	case code == 404:
+		return ErrNotFound
+	case code == 503:
+		return ErrTimeout
+	default:
+		return statusCode(code)
+	}
+}
@@ -0,0 +1,142 @@
+package riak
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// serves counters in memory; the bucket
+// "nomult" doesn't have allow_mult set
+func counterServer() *httptest.Server {
+	var lock sync.Mutex
+	ctrs := make(map[string]int64)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		seg := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		btype := ""
+		if len(seg) > 2 && seg[0] == "types" {
+			btype, seg = seg[1], seg[2:]
+		}
+		if len(seg) != 4 || seg[2] != "counters" {
+			w.WriteHeader(400)
+			return
+		}
+		if seg[1] == "nomult" {
+			w.WriteHeader(409)
+			io.WriteString(w, "Counters require bucket property 'allow_mult=true'")
+			return
+		}
+		name := btype + "/" + seg[1] + "/" + seg[3]
+		val, ok := ctrs[name]
+		switch r.Method {
+		case "POST":
+			body, _ := io.ReadAll(r.Body)
+			delta, err := strconv.ParseInt(string(body), 10, 64)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			ctrs[name] = val + delta
+			if r.URL.Query().Get("returnvalue") != "true" {
+				w.WriteHeader(204)
+				return
+			}
+			io.WriteString(w, strconv.FormatInt(ctrs[name], 10))
+		case "GET":
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			io.WriteString(w, strconv.FormatInt(val, 10))
+		}
+	}))
+}
+
+func testCounters(t *testing.T, c *Client) {
+	if _, err := c.GetCounter("testing", "hits", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+	v, err := c.IncrementCounter("testing", "hits", 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0 {
+		t.Errorf("expected no value without returnvalue; got %d", v)
+	}
+	v, err = c.IncrementCounter("testing", "hits", -7, map[string]string{"returnvalue": "true", "w": "quorum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != -2 {
+		t.Errorf("expected -2; got %d", v)
+	}
+	v, err = c.GetCounter("testing", "hits", map[string]string{"r": "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != -2 {
+		t.Errorf("expected -2; got %d", v)
+	}
+	if _, err := c.IncrementCounter("nomult", "hits", 1, nil); err != ErrCounterAllowMult {
+		t.Errorf("expected ErrCounterAllowMult; got %v", err)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	srv := counterServer()
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+	testCounters(t, c)
+
+	// counters in a bucket type are separate
+	tc := c.BucketType("ctrs")
+	if _, err := tc.GetCounter("testing", "hits", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+	if v, err := tc.IncrementCounter("testing", "hits", 3, map[string]string{"returnvalue": "true"}); err != nil || v != 3 {
+		t.Errorf("incremented to %d %v", v, err)
+	}
+	if v, _ := c.GetCounter("testing", "hits", nil); v != -2 {
+		t.Errorf("expected -2 in the default type; got %d", v)
+	}
+}
+
+func TestPBCounters(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 1))
+	defer c.Close()
+	testCounters(t, c)
+
+	// the request body is closed
+	body := &closeCounter{Reader: strings.NewReader("1")}
+	req, _ := http.NewRequest("POST", "/buckets/testing/counters/closed", body)
+	res, err := c.cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if body.closed != 1 {
+		t.Errorf("request body closed %d times", body.closed)
+	}
+
+	if _, err := c.BucketType("ctrs").GetCounter("testing", "hits", nil); err != errPBUnsupported {
+		t.Errorf("expected errPBUnsupported; got %v", err)
+	}
+}
+
+type closeCounter struct {
+	io.Reader
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
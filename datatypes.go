@@ -0,0 +1,572 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Data types (CRDTs) live in buckets whose bucket type sets
+// the "datatype" property. Each data type is read with one
+// of the Fetch methods and changed by sending a batch of
+// operations with one of the Update methods. An empty bucket
+// type means the client's bucket type (see BucketType), and an
+// empty key has riak generate one when a set or map is updated.
+// Data types are only supported over HTTP.
+
+// ErrNoContext is returned when an operation removes
+// something but doesn't carry the context of a previous
+// fetch. Riak needs the context in order to know which
+// adds a remove applies to.
+var ErrNoContext = errors.New("riak: removes need the context from a fetch")
+
+// Set is a set of strings
+type Set struct {
+	Key     string // generated by riak if the set was updated without one
+	Values  []string
+	Context string // opaque; pass to SetOp.Context in order to remove
+}
+
+// Contains returns whether or not 'v' is in the set
+func (s *Set) Contains(v string) bool {
+	for _, e := range s.Values {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOp is a batch of operations on a set.
+// Create one with NewSetOp.
+type SetOp struct {
+	adds    []string
+	removes []string
+	context string
+}
+
+// NewSetOp creates an empty batch of set operations
+func NewSetOp() *SetOp { return &SetOp{} }
+
+// Add adds elements to the set
+func (s *SetOp) Add(v ...string) *SetOp {
+	s.adds = append(s.adds, v...)
+	return s
+}
+
+// Remove removes elements from the set.
+// Removes need the set's context.
+func (s *SetOp) Remove(v ...string) *SetOp {
+	s.removes = append(s.removes, v...)
+	return s
+}
+
+// Context sets the context returned by a previous fetch
+func (s *SetOp) Context(ctx string) *SetOp {
+	s.context = ctx
+	return s
+}
+
+func (s *SetOp) body() map[string]interface{} {
+	b := make(map[string]interface{})
+	if len(s.adds) > 0 {
+		b["add_all"] = s.adds
+	}
+	if len(s.removes) > 0 {
+		b["remove_all"] = s.removes
+	}
+	return b
+}
+
+// Map is a map whose fields are themselves data types.
+// Fields are named in riak by their name and kind, so
+// e.g. a register and a flag may both be named "x".
+type Map struct {
+	Key       string // generated by riak if the map was updated without one; empty for nested maps
+	Registers map[string]string
+	Flags     map[string]bool
+	Counters  map[string]int64
+	Sets      map[string][]string
+	Maps      map[string]*Map
+	Context   string // opaque; pass to MapOp.Context in order to remove
+}
+
+// riak sends fields as "name_kind": value
+func (m *Map) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	m.Registers = make(map[string]string)
+	m.Flags = make(map[string]bool)
+	m.Counters = make(map[string]int64)
+	m.Sets = make(map[string][]string)
+	m.Maps = make(map[string]*Map)
+	for field, v := range raw {
+		i := strings.LastIndexByte(field, '_')
+		if i < 0 {
+			continue
+		}
+		name := field[:i]
+		var err error
+		switch field[i+1:] {
+		case "register":
+			var s string
+			err = json.Unmarshal(v, &s)
+			m.Registers[name] = s
+		case "flag":
+			var f bool
+			err = json.Unmarshal(v, &f)
+			m.Flags[name] = f
+		case "counter":
+			var n int64
+			err = json.Unmarshal(v, &n)
+			m.Counters[name] = n
+		case "set":
+			var s []string
+			err = json.Unmarshal(v, &s)
+			m.Sets[name] = s
+		case "map":
+			sub := new(Map)
+			err = json.Unmarshal(v, sub)
+			m.Maps[name] = sub
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MapOp is a batch of operations on a map.
+// Create one with NewMapOp. Updating a field
+// creates it if it doesn't exist.
+//
+//	op := NewMapOp().
+//		SetRegister("name", "Alice").
+//		SetFlag("admin", true).
+//		IncrementCounter("logins", 1)
+//	op.Set("tags").Add("go", "riak")
+//	op.Map("address").SetRegister("city", "Paris")
+type MapOp struct {
+	updates map[string]interface{} // by name_kind
+	removes []string               // name_kind
+	context string
+}
+
+// NewMapOp creates an empty batch of map operations
+func NewMapOp() *MapOp { return &MapOp{updates: make(map[string]interface{})} }
+
+func (m *MapOp) update(field string, v interface{}) {
+	for i, r := range m.removes {
+		if r == field {
+			m.removes = append(m.removes[:i], m.removes[i+1:]...)
+			break
+		}
+	}
+	m.updates[field] = v
+}
+
+// SetRegister sets the register 'name' to 'v'
+func (m *MapOp) SetRegister(name string, v string) *MapOp {
+	m.update(name+"_register", v)
+	return m
+}
+
+// SetFlag enables or disables the flag 'name'.
+// Disabling a flag needs the map's context.
+func (m *MapOp) SetFlag(name string, v bool) *MapOp {
+	if v {
+		m.update(name+"_flag", "enable")
+	} else {
+		m.update(name+"_flag", "disable")
+	}
+	return m
+}
+
+// IncrementCounter adds 'delta' to the counter 'name'.
+// Increments of the same counter in one batch are summed.
+func (m *MapOp) IncrementCounter(name string, delta int64) *MapOp {
+	field := name + "_counter"
+	if n, ok := m.updates[field].(int64); ok {
+		delta += n
+	}
+	m.update(field, delta)
+	return m
+}
+
+// Set returns the batch of operations on the set 'name'
+func (m *MapOp) Set(name string) *SetOp {
+	field := name + "_set"
+	if s, ok := m.updates[field].(*SetOp); ok {
+		return s
+	}
+	s := NewSetOp()
+	m.update(field, s)
+	return s
+}
+
+// Map returns the batch of operations on the map 'name'
+func (m *MapOp) Map(name string) *MapOp {
+	field := name + "_map"
+	if sub, ok := m.updates[field].(*MapOp); ok {
+		return sub
+	}
+	sub := NewMapOp()
+	m.update(field, sub)
+	return sub
+}
+
+// Remove removes the field 'name' of 'kind', which is one
+// of "register", "flag", "counter", "set" or "map", and
+// cancels any update of that field in this batch.
+// Removes need the map's context.
+func (m *MapOp) Remove(name string, kind string) *MapOp {
+	field := name + "_" + kind
+	delete(m.updates, field)
+	for _, r := range m.removes {
+		if r == field {
+			return m
+		}
+	}
+	m.removes = append(m.removes, field)
+	return m
+}
+
+// Context sets the context returned by a previous fetch
+func (m *MapOp) Context(ctx string) *MapOp {
+	m.context = ctx
+	return m
+}
+
+// whether the op needs a context; nested
+// ops use the context of the outermost map
+func (m *MapOp) removing() bool {
+	if len(m.removes) > 0 {
+		return true
+	}
+	for _, u := range m.updates {
+		switch u := u.(type) {
+		case string:
+			if u == "disable" {
+				return true
+			}
+		case *SetOp:
+			if len(u.removes) > 0 {
+				return true
+			}
+		case *MapOp:
+			if u.removing() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *MapOp) body() map[string]interface{} {
+	b := make(map[string]interface{})
+	if len(m.updates) > 0 {
+		up := make(map[string]interface{}, len(m.updates))
+		for field, u := range m.updates {
+			switch u := u.(type) {
+			case *SetOp:
+				up[field] = u.body()
+			case *MapOp:
+				up[field] = u.body()
+			default:
+				up[field] = u
+			}
+		}
+		b["update"] = up
+	}
+	if len(m.removes) > 0 {
+		rm := append([]string(nil), m.removes...)
+		sort.Strings(rm)
+		b["remove"] = rm
+	}
+	return b
+}
+
+// HLLOp is a batch of additions to a HyperLogLog.
+// Create one with NewHLLOp.
+type HLLOp struct {
+	adds []string
+}
+
+// NewHLLOp creates an empty batch of HyperLogLog operations
+func NewHLLOp() *HLLOp { return &HLLOp{} }
+
+// Add adds elements to the HyperLogLog
+func (h *HLLOp) Add(v ...string) *HLLOp {
+	h.adds = append(h.adds, v...)
+	return h
+}
+
+func (h *HLLOp) body() map[string]interface{} {
+	b := make(map[string]interface{})
+	if h != nil && len(h.adds) > 0 {
+		b["add_all"] = h.adds
+	}
+	return b
+}
+
+// a fetched data type, or the result of an update
+type dtResponse struct {
+	Type    string          `json:"type"`
+	Value   json.RawMessage `json:"value"`
+	Context string          `json:"context"`
+	key     string
+}
+
+// /types/btype/buckets/bucket/datatypes[/key]?opts
+func dtpath(btype string, bucket string, key string, opts map[string]string) string {
+	path := "/types/" + btype + "/buckets/" + bucket + "/datatypes"
+	if key != "" {
+		path += "/" + key
+	}
+	if len(opts) > 0 {
+		query := make(url.Values)
+		for k, v := range opts {
+			query.Set(k, v)
+		}
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+func (c *Client) dtFetch(ctx context.Context, kind string, btype string, bucket string, key string, opts map[string]string) (*dtResponse, error) {
+	if btype == "" {
+		btype = c.btype
+	}
+	if btype == "" {
+		return nil, errors.New("riak: data types need a bucket type")
+	}
+	res, err := c.do(ctx, "GET", dtpath(btype, bucket, key, opts), nil)
+	if err != nil {
+		return nil, err
+	}
+	dt, err := dtResult(res.StatusCode, res.Body, kind)
+	if err != nil {
+		return nil, err
+	}
+	dt.key = key
+	return dt, nil
+}
+
+func (c *Client) dtUpdate(ctx context.Context, kind string, btype string, bucket string, key string, body map[string]interface{}, opts map[string]string) (*dtResponse, error) {
+	if btype == "" {
+		btype = c.btype
+	}
+	if btype == "" {
+		return nil, errors.New("riak: data types need a bucket type")
+	}
+	q := make(map[string]string, len(opts)+1)
+	for k, v := range opts {
+		q[k] = v
+	}
+	q["returnbody"] = "true"
+	p, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newreq(ctx, "POST", dtpath(btype, bucket, key, q), bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// updates aren't idempotent
+	res, err := c.sendRetry(req, c.retry != nil && c.retry.RetryUpdates)
+	if err != nil {
+		return nil, err
+	}
+	dt, err := dtResult(res.StatusCode, res.Body, kind)
+	if err != nil {
+		return nil, err
+	}
+	dt.key = key
+	if key == "" {
+		// 201 Created, with Location: .../datatypes/key
+		loc := res.Header.Get("Location")
+		dt.key = loc[strings.LastIndexByte(loc, '/')+1:]
+	}
+	return dt, nil
+}
+
+// closes 'body'
+func dtResult(code int, body io.ReadCloser, kind string) (*dtResponse, error) {
+	defer body.Close()
+	switch code {
+	case 200, 201:
+	case 400:
+		return nil, ErrBadRequest
+	case 404:
+		return nil, ErrNotFound
+	case 412:
+		return nil, ErrModified
+	case 503:
+		return nil, ErrTimeout
+	default:
+		return nil, statusCode(code)
+	}
+	dt := new(dtResponse)
+	if err := json.NewDecoder(body).Decode(dt); err != nil {
+		return nil, err
+	}
+	if dt.Type != kind {
+		return nil, fmt.Errorf("riak: expected a %s; got a %s", kind, dt.Type)
+	}
+	return dt, nil
+}
+
+func (dt *dtResponse) set() (*Set, error) {
+	s := &Set{Key: dt.key, Context: dt.Context}
+	if err := json.Unmarshal(dt.Value, &s.Values); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (dt *dtResponse) mapval() (*Map, error) {
+	m := new(Map)
+	if err := json.Unmarshal(dt.Value, m); err != nil {
+		return nil, err
+	}
+	m.Key, m.Context = dt.key, dt.Context
+	return m, nil
+}
+
+// FetchSet gets the set at bucket/key in the bucket type 'btype'.
+// Valid options are the same as for Fetch, except 'vtag'.
+func (c *Client) FetchSet(btype string, bucket string, key string, opts map[string]string) (*Set, error) {
+	return c.FetchSetContext(context.Background(), btype, bucket, key, opts)
+}
+
+// FetchSetContext is like FetchSet, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FetchSetContext(ctx context.Context, btype string, bucket string, key string, opts map[string]string) (*Set, error) {
+	dt, err := c.dtFetch(ctx, "set", btype, bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dt.set()
+}
+
+// UpdateSet applies 'op' to the set at bucket/key in the bucket
+// type 'btype' and returns the updated set. Valid options are
+// the same as for Store.
+func (c *Client) UpdateSet(btype string, bucket string, key string, op *SetOp, opts map[string]string) (*Set, error) {
+	return c.UpdateSetContext(context.Background(), btype, bucket, key, op, opts)
+}
+
+// UpdateSetContext is like UpdateSet, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) UpdateSetContext(ctx context.Context, btype string, bucket string, key string, op *SetOp, opts map[string]string) (*Set, error) {
+	if op == nil {
+		op = NewSetOp()
+	}
+	if len(op.removes) > 0 && op.context == "" {
+		return nil, ErrNoContext
+	}
+	body := op.body()
+	if op.context != "" {
+		body["context"] = op.context
+	}
+	dt, err := c.dtUpdate(ctx, "set", btype, bucket, key, body, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dt.set()
+}
+
+// FetchMap gets the map at bucket/key in the bucket type 'btype'.
+// Valid options are the same as for Fetch, except 'vtag'.
+func (c *Client) FetchMap(btype string, bucket string, key string, opts map[string]string) (*Map, error) {
+	return c.FetchMapContext(context.Background(), btype, bucket, key, opts)
+}
+
+// FetchMapContext is like FetchMap, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FetchMapContext(ctx context.Context, btype string, bucket string, key string, opts map[string]string) (*Map, error) {
+	dt, err := c.dtFetch(ctx, "map", btype, bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dt.mapval()
+}
+
+// UpdateMap applies 'op' to the map at bucket/key in the bucket
+// type 'btype' and returns the updated map. Valid options are
+// the same as for Store.
+func (c *Client) UpdateMap(btype string, bucket string, key string, op *MapOp, opts map[string]string) (*Map, error) {
+	return c.UpdateMapContext(context.Background(), btype, bucket, key, op, opts)
+}
+
+// UpdateMapContext is like UpdateMap, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) UpdateMapContext(ctx context.Context, btype string, bucket string, key string, op *MapOp, opts map[string]string) (*Map, error) {
+	if op == nil {
+		op = NewMapOp()
+	}
+	if op.context == "" && op.removing() {
+		return nil, ErrNoContext
+	}
+	body := op.body()
+	if op.context != "" {
+		body["context"] = op.context
+	}
+	dt, err := c.dtUpdate(ctx, "map", btype, bucket, key, body, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dt.mapval()
+}
+
+// FetchHLL gets the estimated number of distinct elements
+// in the HyperLogLog at bucket/key in the bucket type 'btype'.
+// Valid options are the same as for Fetch, except 'vtag'.
+func (c *Client) FetchHLL(btype string, bucket string, key string, opts map[string]string) (uint64, error) {
+	return c.FetchHLLContext(context.Background(), btype, bucket, key, opts)
+}
+
+// FetchHLLContext is like FetchHLL, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FetchHLLContext(ctx context.Context, btype string, bucket string, key string, opts map[string]string) (uint64, error) {
+	dt, err := c.dtFetch(ctx, "hll", btype, bucket, key, opts)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	err = json.Unmarshal(dt.Value, &n)
+	return n, err
+}
+
+// UpdateHLL applies 'op' to the HyperLogLog at bucket/key in
+// the bucket type 'btype' and returns its new estimate. Valid
+// options are the same as for Store. Unlike UpdateSet and
+// UpdateMap, UpdateHLL needs a key.
+func (c *Client) UpdateHLL(btype string, bucket string, key string, op *HLLOp, opts map[string]string) (uint64, error) {
+	return c.UpdateHLLContext(context.Background(), btype, bucket, key, op, opts)
+}
+
+// UpdateHLLContext is like UpdateHLL, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) UpdateHLLContext(ctx context.Context, btype string, bucket string, key string, op *HLLOp, opts map[string]string) (uint64, error) {
+	if key == "" {
+		// there'd be no way to return the generated key
+		return 0, errors.New("riak: UpdateHLL needs a key")
+	}
+	dt, err := c.dtUpdate(ctx, "hll", btype, bucket, key, op.body(), opts)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	err = json.Unmarshal(dt.Value, &n)
+	return n, err
+}
@@ -0,0 +1,433 @@
+package riak
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDT serves data types from in-memory CRDTs. The
+// bucket types "sets", "maps" and "hlls" hold data
+// types of the corresponding kind.
+type fakeDT struct {
+	sync.Mutex
+	sets    map[string]map[string]bool
+	maps    map[string]*fakeMap
+	hlls    map[string]map[string]bool
+	version int
+	bodies  []map[string]interface{} // every update received
+}
+
+type fakeMap struct {
+	regs  map[string]string
+	flags map[string]bool
+	ctrs  map[string]int64
+	sets  map[string]map[string]bool
+	maps  map[string]*fakeMap
+}
+
+func newFakeMap() *fakeMap {
+	return &fakeMap{
+		regs:  make(map[string]string),
+		flags: make(map[string]bool),
+		ctrs:  make(map[string]int64),
+		sets:  make(map[string]map[string]bool),
+		maps:  make(map[string]*fakeMap),
+	}
+}
+
+func newFakeDT() *fakeDT {
+	return &fakeDT{
+		sets: make(map[string]map[string]bool),
+		maps: make(map[string]*fakeMap),
+		hlls: make(map[string]map[string]bool),
+	}
+}
+
+func members(s map[string]bool) []string {
+	out := []string{}
+	for e := range s {
+		out = append(out, e)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// returns false if an element was removed that wasn't there
+func applySet(s map[string]bool, op map[string]interface{}) bool {
+	for _, e := range op["add_all"].([]interface{}) {
+		s[e.(string)] = true
+	}
+	if rm, ok := op["remove_all"].([]interface{}); ok {
+		for _, e := range rm {
+			if !s[e.(string)] {
+				return false
+			}
+			delete(s, e.(string))
+		}
+	}
+	return true
+}
+
+func (m *fakeMap) apply(op map[string]interface{}) bool {
+	if rm, ok := op["remove"].([]interface{}); ok {
+		for _, f := range rm {
+			name, kind := splitField(f.(string))
+			switch kind {
+			case "register":
+				delete(m.regs, name)
+			case "flag":
+				delete(m.flags, name)
+			case "counter":
+				delete(m.ctrs, name)
+			case "set":
+				delete(m.sets, name)
+			case "map":
+				delete(m.maps, name)
+			}
+		}
+	}
+	up, _ := op["update"].(map[string]interface{})
+	for f, v := range up {
+		name, kind := splitField(f)
+		switch kind {
+		case "register":
+			m.regs[name] = v.(string)
+		case "flag":
+			m.flags[name] = v == "enable"
+		case "counter":
+			m.ctrs[name] += int64(v.(float64))
+		case "set":
+			if m.sets[name] == nil {
+				m.sets[name] = make(map[string]bool)
+			}
+			sub := v.(map[string]interface{})
+			if sub["add_all"] == nil {
+				sub["add_all"] = []interface{}{}
+			}
+			if !applySet(m.sets[name], sub) {
+				return false
+			}
+		case "map":
+			if m.maps[name] == nil {
+				m.maps[name] = newFakeMap()
+			}
+			if !m.maps[name].apply(v.(map[string]interface{})) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *fakeMap) value() map[string]interface{} {
+	v := make(map[string]interface{})
+	for k, r := range m.regs {
+		v[k+"_register"] = r
+	}
+	for k, f := range m.flags {
+		v[k+"_flag"] = f
+	}
+	for k, c := range m.ctrs {
+		v[k+"_counter"] = c
+	}
+	for k, s := range m.sets {
+		v[k+"_set"] = members(s)
+	}
+	for k, sub := range m.maps {
+		v[k+"_map"] = sub.value()
+	}
+	return v
+}
+
+func splitField(f string) (string, string) {
+	i := strings.LastIndexByte(f, '_')
+	return f[:i], f[i+1:]
+}
+
+func (f *fakeDT) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	// /types/T/buckets/B/datatypes/K
+	seg := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(seg) == 5 && r.Method == "POST" {
+		// riak generates a key
+		key := "generated" + strconv.Itoa(f.version)
+		w.Header().Set("Location", r.URL.Path+"/"+key)
+		seg = append(seg, key)
+	}
+	if len(seg) != 6 || seg[0] != "types" || seg[4] != "datatypes" {
+		w.WriteHeader(400)
+		return
+	}
+	name := seg[3] + "/" + seg[5]
+	kind := strings.TrimSuffix(seg[1], "s")
+
+	if r.Method == "POST" {
+		var op map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		f.bodies = append(f.bodies, op)
+		if op["add_all"] == nil {
+			// without changing what was received
+			cp := map[string]interface{}{"add_all": []interface{}{}}
+			for k, v := range op {
+				if v != nil {
+					cp[k] = v
+				}
+			}
+			op = cp
+		}
+		ok := true
+		switch kind {
+		case "set":
+			if f.sets[name] == nil {
+				f.sets[name] = make(map[string]bool)
+			}
+			ok = applySet(f.sets[name], op)
+		case "map":
+			if f.maps[name] == nil {
+				f.maps[name] = newFakeMap()
+			}
+			ok = f.maps[name].apply(op)
+		case "hll":
+			if f.hlls[name] == nil {
+				f.hlls[name] = make(map[string]bool)
+			}
+			applySet(f.hlls[name], op)
+		}
+		if !ok {
+			w.WriteHeader(412)
+			return
+		}
+		f.version++
+	}
+
+	res := map[string]interface{}{"type": kind, "context": "ctx" + strconv.Itoa(f.version)}
+	switch kind {
+	case "set":
+		s, ok := f.sets[name]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		res["value"] = members(s)
+	case "map":
+		m, ok := f.maps[name]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		res["value"] = m.value()
+	case "hll":
+		h, ok := f.hlls[name]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		res["value"] = len(h)
+		delete(res, "context")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Location") != "" {
+		w.WriteHeader(201)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestDataTypeSets(t *testing.T) {
+	f := newFakeDT()
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	if _, err := c.FetchSet("sets", "users", "tags", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+	s, err := c.UpdateSet("sets", "users", "tags", NewSetOp().Add("go", "riak").Add("erlang"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Values) != 3 || !s.Contains("erlang") || s.Context == "" {
+		t.Errorf("bad set %+v", s)
+	}
+
+	// removes need a context
+	if _, err := c.UpdateSet("sets", "users", "tags", NewSetOp().Remove("go"), nil); err != ErrNoContext {
+		t.Errorf("expected ErrNoContext; got %v", err)
+	}
+	s, err = c.FetchSet("sets", "users", "tags", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = c.UpdateSet("sets", "users", "tags", NewSetOp().Remove("go").Add("c").Context(s.Context), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Values) != 3 || s.Contains("go") || !s.Contains("c") {
+		t.Errorf("bad set %+v", s)
+	}
+	last := f.bodies[len(f.bodies)-1]
+	if last["context"] == nil {
+		t.Errorf("context not sent: %v", last)
+	}
+
+	// wrong kind of data type
+	if _, err := c.FetchMap("sets", "users", "tags", nil); err == nil {
+		t.Error("expected an error fetching a set as a map")
+	}
+	if _, err := c.FetchSet("", "users", "tags", nil); err == nil {
+		t.Error("expected an error without a bucket type")
+	}
+}
+
+func TestDataTypeMaps(t *testing.T) {
+	f := newFakeDT()
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	op := NewMapOp().
+		SetRegister("name", "Alice").
+		SetFlag("admin", true).
+		IncrementCounter("logins", 2).
+		IncrementCounter("logins", 3)
+	op.Set("tags").Add("go")
+	op.Set("tags").Add("riak")
+	op.Map("address").SetRegister("city", "Paris").IncrementCounter("visits", 1)
+
+	m, err := c.UpdateMap("maps", "users", "alice", op, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.bodies) != 1 {
+		t.Errorf("expected one batched request; got %d", len(f.bodies))
+	}
+	if m.Registers["name"] != "Alice" || !m.Flags["admin"] || m.Counters["logins"] != 5 {
+		t.Errorf("bad map %+v", m)
+	}
+	if len(m.Sets["tags"]) != 2 {
+		t.Errorf("bad set field %v", m.Sets["tags"])
+	}
+	addr := m.Maps["address"]
+	if addr == nil || addr.Registers["city"] != "Paris" || addr.Counters["visits"] != 1 {
+		t.Errorf("bad nested map %+v", addr)
+	}
+
+	// disabling flags and removing nested set
+	// elements need the context as well
+	if _, err := c.UpdateMap("maps", "users", "alice", NewMapOp().SetFlag("admin", false), nil); err != ErrNoContext {
+		t.Errorf("expected ErrNoContext; got %v", err)
+	}
+	rm := NewMapOp()
+	rm.Map("address").Set("langs").Remove("fr")
+	if _, err := c.UpdateMap("maps", "users", "alice", rm, nil); err != ErrNoContext {
+		t.Errorf("expected ErrNoContext; got %v", err)
+	}
+
+	m, err = c.FetchMap("maps", "users", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op = NewMapOp().
+		Context(m.Context).
+		SetRegister("nickname", "al").
+		Remove("nickname", "register"). // cancels the update
+		Remove("address", "map").
+		SetFlag("admin", false)
+	op.Set("tags").Remove("go")
+	m, err = c.UpdateMap("maps", "users", "alice", op, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Registers["nickname"]; ok {
+		t.Error("removed register was set")
+	}
+	if m.Maps["address"] != nil || m.Flags["admin"] || len(m.Sets["tags"]) != 1 {
+		t.Errorf("bad map %+v", m)
+	}
+	last := f.bodies[len(f.bodies)-1]
+	if rm, _ := last["remove"].([]interface{}); len(rm) != 2 {
+		t.Errorf("expected two removes; got %v", last["remove"])
+	}
+}
+
+func TestDataTypeHLL(t *testing.T) {
+	srv := httptest.NewServer(newFakeDT())
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	n, err := c.UpdateHLL("hlls", "visits", "today", NewHLLOp().Add("alice", "bob", "alice"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2; got %d", n)
+	}
+	n, err = c.FetchHLL("hlls", "visits", "today", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2; got %d", n)
+	}
+}
+
+func TestDataTypeDefaults(t *testing.T) {
+	f := newFakeDT()
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	// the client's bucket type is used
+	sets := c.BucketType("sets")
+	if _, err := sets.UpdateSet("", "users", "tags", NewSetOp().Add("go"), nil); err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.FetchSet("sets", "users", "tags", nil)
+	if err != nil || !s.Contains("go") || s.Key != "tags" {
+		t.Errorf("fetched %+v %v", s, err)
+	}
+
+	// riak generates keys
+	s, err = sets.UpdateSet("", "users", "", NewSetOp().Add("riak"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Key == "" || !s.Contains("riak") {
+		t.Fatalf("bad set %+v", s)
+	}
+	if got, err := sets.FetchSet("", "users", s.Key, nil); err != nil || !got.Contains("riak") {
+		t.Errorf("fetched %+v %v", got, err)
+	}
+	m, err := c.UpdateMap("maps", "users", "", NewMapOp().SetRegister("name", "bob"), nil)
+	if err != nil || m.Key == "" || m.Registers["name"] != "bob" {
+		t.Errorf("bad map %+v %v", m, err)
+	}
+	if _, err := c.UpdateHLL("hlls", "visits", "", NewHLLOp().Add("x"), nil); err == nil {
+		t.Error("expected an error updating a HyperLogLog without a key")
+	}
+
+	// empty and nil ops don't send null adds
+	if _, err := c.UpdateHLL("hlls", "visits", "today", NewHLLOp(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateHLL("hlls", "visits", "today", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateSet("sets", "users", "tags", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range f.bodies[len(f.bodies)-3:] {
+		if _, ok := body["add_all"]; ok {
+			t.Errorf("empty op sent %v", body)
+		}
+	}
+}
@@ -1,21 +1,26 @@
 package riak
 
 import (
-	"net/http"
+	"context"
 )
 
 // Delete removes an object from the database
 func (c *Client) Delete(o *Object, opts map[string]string) error {
+	return c.DeleteContext(context.Background(), o, opts)
+}
+
+// DeleteContext is like Delete, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) DeleteContext(ctx context.Context, o *Object, opts map[string]string) error {
 	if o.Key == "" || o.Bucket == "" {
 		return ErrNotFound
 	}
-	req, err := http.NewRequest("DELETE", c.host+o.path(), nil)
+	req, err := c.newreq(ctx, "DELETE", c.objpath(o), nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("X-Riak-ClientId", c.id)
 	req.Header.Set("X-Riak-Vclock", o.Vclock)
-	res, err := c.cl.Do(req)
+	res, err := c.send(req)
 	if err != nil {
 		return err
 	}
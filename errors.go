@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // ErrModified is returned when an if-not-modified precondition fails
@@ -22,7 +23,9 @@ var ErrTimeout = errors.New("riak request timeout (503)")
 
 // ErrMultipleVclocks is an object returned when
 // multiple objects reside at the same bucket/key tuple.
-// It contains the vector clocks of each object.
+// It contains the vtags of each sibling, which can be
+// passed to Fetch as the 'vtag' option. Use FetchSiblings
+// or a Resolver to retrieve the siblings themselves.
 type ErrMultipleVclocks struct {
 	Vclocks []string
 }
@@ -34,11 +37,15 @@ func (e *ErrMultipleVclocks) Error() string {
 func multiple(res *http.Response) error {
 	rd := bufio.NewReader(res.Body)
 	e := new(ErrMultipleVclocks)
-	for line, err := rd.ReadString('\n'); err != nil; {
-		if line == "Siblings:" {
-			continue
+	for {
+		line, err := rd.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" && line != "Siblings:" {
+			e.Vclocks = append(e.Vclocks, line)
+		}
+		if err != nil {
+			break
 		}
-		e.Vclocks = append(e.Vclocks, line)
 	}
 	res.Body.Close()
 	return e
@@ -0,0 +1,25 @@
+//go:build !riak
+
+package riak
+
+import (
+	"os"
+	"testing"
+
+	"github.com/philhofer/riak/riaktest"
+)
+
+// Without the riak build tag, the tests that need
+// a riak node run against an in-memory fake.
+func TestMain(m *testing.M) {
+	srv := riaktest.NewServer()
+	testHost = srv.URL
+	// TestVclockCollision expects siblings
+	err := NewClient(testHost, "setup").SetBucketProps("collision", &BucketProps{Mult: true})
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
@@ -1,7 +1,7 @@
 package riak
 
 import (
-	"net/http"
+	"context"
 	"net/url"
 )
 
@@ -12,12 +12,23 @@ import (
 // - 'basic_quorum':(true/false)
 // - 'notfound_ok':(true/false)
 // - 'vtag':(vtag) - which sibling to retrieve, if multiple siblings
-// Fetch returns ErrMultipleVclocks if multiple options are available.
+// Fetch returns ErrMultipleVclocks if multiple options are available,
+// unless a Resolver has been registered for the bucket with WithResolver.
 func (c *Client) Fetch(bucket string, key string, opts map[string]string) (*Object, error) {
+	return c.FetchContext(context.Background(), bucket, key, opts)
+}
+
+// FetchContext is like Fetch, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FetchContext(ctx context.Context, bucket string, key string, opts map[string]string) (*Object, error) {
+	if r := c.resolver(bucket); r != nil {
+		return c.FetchResolveContext(ctx, bucket, key, opts, r)
+	}
 	o := newObj()
 	o.Bucket = bucket
 	o.Key = key
-	req, err := http.NewRequest("GET", c.host+o.path(), nil)
+	o.BucketType = c.btype
+	req, err := c.newreq(ctx, "GET", c.objpath(o), nil)
 	if err != nil {
 		Release(o)
 		return nil, err
@@ -31,9 +42,8 @@ func (c *Client) Fetch(bucket string, key string, opts map[string]string) (*Obje
 		}
 		req.URL.RawQuery = query.Encode()
 	}
-	req.Header.Set("X-Riak-ClientId", c.id)
 
-	res, err := c.cl.Do(req)
+	res, err := c.send(req)
 	if err != nil {
 		Release(o)
 		return nil, err
@@ -69,7 +79,13 @@ func (c *Client) Fetch(bucket string, key string, opts map[string]string) (*Obje
 // Update checks if the object has been changed, and if it has,
 // it overwrites the object and returns 'true'.
 func (c *Client) GetUpdate(o *Object, opts map[string]string) (bool, error) {
-	req, err := http.NewRequest("GET", c.host+o.path(), nil)
+	return c.GetUpdateContext(context.Background(), o, opts)
+}
+
+// GetUpdateContext is like GetUpdate, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) GetUpdateContext(ctx context.Context, o *Object, opts map[string]string) (bool, error) {
+	req, err := c.newreq(ctx, "GET", c.objpath(o), nil)
 	if err != nil {
 		return false, err
 	}
@@ -83,11 +99,10 @@ func (c *Client) GetUpdate(o *Object, opts map[string]string) (bool, error) {
 	}
 
 	req.Header.Set("If-None-Match", o.eTag)
-	req.Header.Set("X-Riak-ClientId", c.id)
 
 	o.writeheader(req.Header)
 
-	res, err := c.cl.Do(req)
+	res, err := c.send(req)
 	if err != nil {
 		return false, err
 	}
@@ -117,3 +132,81 @@ func (c *Client) GetUpdate(o *Object, opts map[string]string) (bool, error) {
 	}
 
 }
+
+// Head gets an object's metadata (content type, vclock, links,
+// meta and indexes) without downloading its body, which is left
+// empty. Valid options are the same as for Fetch. If the object
+// has siblings, Head returns an *ErrMultipleVclocks without any
+// vtags, because HEAD responses don't list them.
+func (c *Client) Head(bucket string, key string, opts map[string]string) (*Object, error) {
+	return c.HeadContext(context.Background(), bucket, key, opts)
+}
+
+// HeadContext is like Head, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) HeadContext(ctx context.Context, bucket string, key string, opts map[string]string) (*Object, error) {
+	o := newObj()
+	o.Bucket = bucket
+	o.Key = key
+	o.BucketType = c.btype
+	req, err := c.newreq(ctx, "HEAD", c.objpath(o), nil)
+	if err != nil {
+		Release(o)
+		return nil, err
+	}
+	if opts != nil {
+		query := make(url.Values)
+		for key, val := range opts {
+			query.Set(key, val)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	res, err := c.send(req)
+	if err != nil {
+		Release(o)
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode == 200 {
+		err = o.fromResponse(res.Header, nil)
+		return o, err
+	}
+	Release(o)
+	switch res.StatusCode {
+	case 300:
+		return nil, &ErrMultipleVclocks{}
+	case 400:
+		return nil, ErrBadRequest
+	case 404:
+		return nil, ErrNotFound
+	case 503:
+		return nil, ErrTimeout
+	default:
+		return nil, statusCode(res.StatusCode)
+	}
+}
+
+// Exists returns whether there is an object at bucket/key,
+// without downloading it. Objects with siblings exist.
+// Valid options are the same as for Fetch.
+func (c *Client) Exists(bucket string, key string, opts map[string]string) (bool, error) {
+	return c.ExistsContext(context.Background(), bucket, key, opts)
+}
+
+// ExistsContext is like Exists, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) ExistsContext(ctx context.Context, bucket string, key string, opts map[string]string) (bool, error) {
+	o, err := c.HeadContext(ctx, bucket, key, opts)
+	switch err.(type) {
+	case nil:
+		Release(o)
+		return true, nil
+	case *ErrMultipleVclocks:
+		return true, nil
+	}
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,277 @@
+package riak
+
+import (
+	"errors"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Struct fields can be mapped onto an object's key, secondary
+// indexes, metadata and links with `riak` struct tags:
+//
+//	type User struct {
+//		ID     string `riak:"key"`
+//		Email  string `riak:"index,email_bin"`
+//		Age    int    `riak:"index,age_int"`
+//		Owner  string `riak:"meta,owner"`
+//		Parent Link   `riak:"link,parent"`
+//	}
+//
+// Keys and metadata must be strings. Indexes may be strings
+// or integers, and links must be of type Link. Only the
+// struct's own fields are considered; fields of embedded
+// structs are not.
+
+const (
+	fieldKey = iota
+	fieldIndex
+	fieldMeta
+	fieldLink
+)
+
+type fieldInfo struct {
+	index int    // struct field index
+	kind  int    // fieldKey, fieldIndex, etc.
+	name  string // index, meta or link name
+}
+
+// ErrFieldType is returned when a tagged struct
+// field has a type that can't be mapped.
+type ErrFieldType struct {
+	Struct string
+	Field  string
+	Type   string
+	Tag    string
+}
+
+func (e *ErrFieldType) Error() string {
+	return "riak: field " + e.Struct + "." + e.Field + " of type " + e.Type + " can't be used for `riak:\"" + e.Tag + "\"`"
+}
+
+// ErrBadTag is returned when a `riak` struct tag can't be parsed.
+type ErrBadTag struct {
+	Struct string
+	Field  string
+	Tag    string
+}
+
+func (e *ErrBadTag) Error() string {
+	return "riak: bad struct tag `riak:\"" + e.Tag + "\"` on field " + e.Struct + "." + e.Field
+}
+
+type fieldPlan struct {
+	fields []fieldInfo
+	err    error
+}
+
+// per-type plans
+var plans sync.Map
+
+func planFor(t reflect.Type) *fieldPlan {
+	if p, ok := plans.Load(t); ok {
+		return p.(*fieldPlan)
+	}
+	p := buildPlan(t)
+	plans.Store(t, p)
+	return p
+}
+
+func buildPlan(t reflect.Type) *fieldPlan {
+	p := new(fieldPlan)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("riak")
+		if !ok || tag == "-" {
+			continue
+		}
+		info := fieldInfo{index: i}
+		kind, name, _ := strings.Cut(tag, ",")
+		switch kind {
+		case "key":
+			info.kind = fieldKey
+		case "index":
+			info.kind = fieldIndex
+		case "meta":
+			info.kind = fieldMeta
+		case "link":
+			info.kind = fieldLink
+		default:
+			p.err = &ErrBadTag{Struct: t.Name(), Field: f.Name, Tag: tag}
+			return p
+		}
+		if (info.kind == fieldKey) != (name == "") || !f.IsExported() {
+			p.err = &ErrBadTag{Struct: t.Name(), Field: f.Name, Tag: tag}
+			return p
+		}
+		info.name = name
+		if !fieldTypeOK(info.kind, f.Type) {
+			p.err = &ErrFieldType{Struct: t.Name(), Field: f.Name, Type: f.Type.String(), Tag: tag}
+			return p
+		}
+		p.fields = append(p.fields, info)
+	}
+	return p
+}
+
+var linkType = reflect.TypeOf(Link{})
+
+func fieldTypeOK(kind int, t reflect.Type) bool {
+	switch kind {
+	case fieldKey, fieldMeta:
+		return t.Kind() == reflect.String
+	case fieldIndex:
+		switch t.Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+		return false
+	case fieldLink:
+		return t == linkType
+	}
+	return false
+}
+
+// structValue returns the struct that 'v' points to
+func structValue(v interface{}, settable bool) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	} else if settable {
+		return rv, errors.New("riak: expected a non-nil pointer to a struct")
+	}
+	if !rv.IsValid() {
+		return rv, errors.New("riak: expected a struct, got nil")
+	}
+	if rv.Kind() != reflect.Struct {
+		return rv, errors.New("riak: expected a struct, got " + rv.Type().String())
+	}
+	return rv, nil
+}
+
+// isStruct returns whether or not 'v' is
+// a struct or a pointer to one
+func isStruct(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t != nil && t.Kind() == reflect.Struct
+}
+
+// WriteFields copies the fields of 'v' tagged with `riak`
+// struct tags into the object's key, indexes, metadata and
+// links. 'v' must be a struct or a pointer to one. Empty
+// keys are not copied.
+func (o *Object) WriteFields(v interface{}) error {
+	rv, err := structValue(v, false)
+	if err != nil {
+		return err
+	}
+	p := planFor(rv.Type())
+	if p.err != nil {
+		return p.err
+	}
+	for _, f := range p.fields {
+		fv := rv.Field(f.index)
+		switch f.kind {
+		case fieldKey:
+			if s := fv.String(); s != "" {
+				o.Key = s
+			}
+		case fieldIndex:
+			if s := formatField(fv); s != "" {
+				o.AddIndex(f.name, s)
+			} else {
+				o.RemoveIndex(f.name)
+			}
+		case fieldMeta:
+			if o.Meta == nil {
+				o.Meta = make(map[string]string)
+			}
+			o.Meta[textproto.CanonicalMIMEHeaderKey(f.name)] = fv.String()
+		case fieldLink:
+			l := fv.Interface().(Link)
+			if l.Bucket == "" && l.Key == "" {
+				o.RemoveLink(f.name)
+			} else {
+				o.AddLink(f.name, l.Bucket, l.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadFields sets the fields of 'v' tagged with `riak` struct
+// tags from the object's key, indexes, metadata and links.
+// 'v' must be a pointer to a struct. Fields whose values aren't
+// present on the object are set to their zero value.
+func (o *Object) ReadFields(v interface{}) error {
+	rv, err := structValue(v, true)
+	if err != nil {
+		return err
+	}
+	p := planFor(rv.Type())
+	if p.err != nil {
+		return p.err
+	}
+	for _, f := range p.fields {
+		fv := rv.Field(f.index)
+		switch f.kind {
+		case fieldKey:
+			fv.SetString(o.Key)
+		case fieldIndex:
+			if err := parseField(fv, o.GetIndex(f.name)); err != nil {
+				return errors.New("riak: index " + f.name + ": " + err.Error())
+			}
+		case fieldMeta:
+			fv.SetString(o.Meta[textproto.CanonicalMIMEHeaderKey(f.name)])
+		case fieldLink:
+			fv.Set(reflect.ValueOf(o.Links[f.name]))
+		}
+	}
+	return nil
+}
+
+func formatField(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	default:
+		return strconv.FormatInt(v.Int(), 10)
+	}
+}
+
+func parseField(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if s == "" {
+			v.SetUint(0)
+			return nil
+		}
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	default:
+		if s == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package riak
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+type taggedUser struct {
+	ID     string `riak:"key"`
+	Email  string `riak:"index,email_bin"`
+	Age    int    `riak:"index,age_int"`
+	Owner  string `riak:"meta,owner"`
+	Team   string `riak:"meta,team"`
+	Parent Link   `riak:"link,parent"`
+	Name   string
+}
+
+func TestFieldsRoundTrip(t *testing.T) {
+	in := taggedUser{
+		ID:     "bob",
+		Email:  "bob@example.com",
+		Age:    42,
+		Owner:  "alice",
+		Team:   "infra",
+		Parent: Link{Bucket: "users", Key: "alice"},
+		Name:   "Bob",
+	}
+	o := &Object{Bucket: "users"}
+	if err := o.WriteFields(&in); err != nil {
+		t.Fatal(err)
+	}
+	if o.Key != "bob" {
+		t.Errorf("expected key %q; got %q", "bob", o.Key)
+	}
+	if o.GetIndex("age_int") != "42" {
+		t.Errorf("expected index age_int=42; got %v", o.Index)
+	}
+
+	// round-trip through headers
+	hdr := make(http.Header)
+	o.writeheader(hdr)
+	got := &Object{Key: o.Key}
+	if err := got.fromResponse(hdr, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := taggedUser{Name: "Bob"}
+	if err := got.ReadFields(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("expected %+v; got %+v", in, out)
+	}
+}
+
+func TestFieldsBadTypes(t *testing.T) {
+	type badIndex struct {
+		Tags []string `riak:"index,tags_bin"`
+	}
+	type badTag struct {
+		Key string `riak:"primary"`
+	}
+	o := &Object{Body: bytes.NewBuffer(nil)}
+	if _, ok := o.WriteFields(badIndex{}).(*ErrFieldType); !ok {
+		t.Error("expected *ErrFieldType for a slice index")
+	}
+	if _, ok := o.WriteFields(badTag{}).(*ErrBadTag); !ok {
+		t.Error("expected *ErrBadTag for an unknown tag")
+	}
+	if err := o.ReadFields(taggedUser{}); err == nil {
+		t.Error("expected an error reading into a non-pointer")
+	}
+}
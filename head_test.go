@@ -0,0 +1,104 @@
+package riak
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/philhofer/riak/riaktest"
+)
+
+func TestHead(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	var methods []string
+	c := NewClient(srv.URL, "testClient", WrapTransport(func(tr Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			methods = append(methods, req.Method)
+			return tr.Do(req)
+		})
+	}))
+
+	o := &Object{Bucket: "blobs", Key: "big", Ctype: "application/octet-stream", Body: bytes.NewBufferString(strings.Repeat("x", 1<<20))}
+	o.Meta = map[string]string{"Owner": "alice"}
+	o.AddIndex("size_int", "1048576")
+	o.AddLink("thumb", "blobs", "small")
+	if err := c.Store(o, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := c.Head("blobs", "big", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Body.Len() != 0 {
+		t.Errorf("Head read %d bytes of body", h.Body.Len())
+	}
+	if h.Vclock != o.Vclock || h.Ctype != "application/octet-stream" || h.Meta["Owner"] != "alice" ||
+		h.GetIndex("size_int") != "1048576" || h.Links["thumb"] != (Link{Bucket: "blobs", Key: "small"}) {
+		t.Errorf("bad metadata %+v", h)
+	}
+	if h.LastModified().IsZero() {
+		t.Error("no Last-Modified")
+	}
+	// etags are usable for conditional fetches
+	if up, err := c.GetUpdate(h, nil); err != nil || up {
+		t.Errorf("GetUpdate after Head: %v %v", up, err)
+	}
+
+	if _, err := c.Head("blobs", "missing", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+	if ok, err := c.Exists("blobs", "big", nil); !ok || err != nil {
+		t.Errorf("Exists: %v %v", ok, err)
+	}
+	if ok, err := c.Exists("blobs", "missing", nil); ok || err != nil {
+		t.Errorf("Exists of a missing key: %v %v", ok, err)
+	}
+	want := []string{"PUT", "HEAD", "GET", "HEAD", "HEAD", "HEAD"}
+	if strings.Join(methods, " ") != strings.Join(want, " ") {
+		t.Errorf("expected requests %v; got %v", want, methods)
+	}
+
+	// siblings
+	if err := c.SetBucketProps("blobs", &BucketProps{Mult: true}); err != nil {
+		t.Fatal(err)
+	}
+	c.Store(&Object{Bucket: "blobs", Key: "big", Body: bytes.NewBufferString("conflict")}, nil)
+	if _, err := c.Head("blobs", "big", nil); err == nil {
+		t.Error("expected an error for siblings")
+	} else if _, ok := err.(*ErrMultipleVclocks); !ok {
+		t.Errorf("expected ErrMultipleVclocks; got %v", err)
+	}
+	if ok, err := c.Exists("blobs", "big", nil); !ok || err != nil {
+		t.Errorf("Exists with siblings: %v %v", ok, err)
+	}
+}
+
+func TestPBHead(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 1))
+	defer c.Close()
+
+	o := &Object{Bucket: "testing", Key: "head", Ctype: "text/plain", Body: bytes.NewBufferString("body")}
+	o.Meta = map[string]string{"Agent": "testing"}
+	if err := c.Store(o, nil); err != nil {
+		t.Fatal(err)
+	}
+	h, err := c.Head("testing", "head", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Body.Len() != 0 || h.Vclock != o.Vclock || h.Meta["Agent"] != "testing" {
+		t.Errorf("bad head %+v", h)
+	}
+	if ok, err := c.Exists("testing", "missing", nil); ok || err != nil {
+		t.Errorf("Exists of a missing key: %v %v", ok, err)
+	}
+}
+
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
@@ -2,23 +2,141 @@ package riak
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"net/url"
+	"strconv"
+)
+
+// Special indexes that every object belongs to.
+// $bucket can only be queried for equality with
+// the bucket name, and $key can be range-queried
+// by key.
+const (
+	BucketIndex = "$bucket"
+	KeyIndex    = "$key"
 )
 
 // IndexLookup returns a list of keys in 'bucket' with 'value' for the tag 'index'
 func (c *Client) IndexLookup(bucket string, index string, value string) (*Keyres, error) {
+	return c.IndexLookupContext(context.Background(), bucket, index, value)
+}
+
+// IndexLookupContext is like IndexLookup, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) IndexLookupContext(ctx context.Context, bucket string, index string, value string) (*Keyres, error) {
 	if bucket == "" || index == "" || value == "" {
 		return nil, errors.New("Cannot have empty string argument.")
 	}
-	path := ipath(bucket, index, value)
-	res, err := c.do("GET", path, nil)
+	return c.QueryContext(ctx, NewIndexQuery(bucket, index).Equal(value))
+}
+
+// IndexQuery is a secondary index query. Create one
+// with NewIndexQuery and then call Equal or Range.
+type IndexQuery struct {
+	bucket       string
+	index        string
+	args         []string // value, or start and end
+	maxResults   int
+	continuation string
+	returnTerms  bool
+	termRegex    string
+}
+
+// NewIndexQuery creates a query of 'index' in 'bucket'
+func NewIndexQuery(bucket string, index string) *IndexQuery {
+	return &IndexQuery{bucket: bucket, index: index}
+}
+
+// Equal matches objects whose index value is 'value'
+func (q *IndexQuery) Equal(value string) *IndexQuery {
+	q.args = []string{value}
+	return q
+}
+
+// Range matches objects whose index value is
+// between 'start' and 'end', inclusive
+func (q *IndexQuery) Range(start string, end string) *IndexQuery {
+	q.args = []string{start, end}
+	return q
+}
+
+// MaxResults limits the number of results returned.
+// If there are more, Keyres.Continuation can be passed
+// to Continuation to get the next page.
+func (q *IndexQuery) MaxResults(n int) *IndexQuery {
+	q.maxResults = n
+	return q
+}
+
+// Continuation resumes a query from
+// where a previous page left off
+func (q *IndexQuery) Continuation(token string) *IndexQuery {
+	q.continuation = token
+	return q
+}
+
+// ReturnTerms makes range queries return the matching
+// index term alongside each key in Keyres.Results
+func (q *IndexQuery) ReturnTerms() *IndexQuery {
+	q.returnTerms = true
+	return q
+}
+
+// TermRegex filters the results of a range
+// query by matching the index term against 'rgx'
+func (q *IndexQuery) TermRegex(rgx string) *IndexQuery {
+	q.termRegex = rgx
+	return q
+}
+
+func (q *IndexQuery) query() url.Values {
+	v := make(url.Values)
+	if q.maxResults > 0 {
+		v.Set("max_results", strconv.Itoa(q.maxResults))
+	}
+	if q.continuation != "" {
+		v.Set("continuation", q.continuation)
+	}
+	if q.returnTerms {
+		v.Set("return_terms", "true")
+	}
+	if q.termRegex != "" {
+		v.Set("term_regex", q.termRegex)
+	}
+	return v
+}
+
+// Query performs a secondary index query
+func (c *Client) Query(q *IndexQuery) (*Keyres, error) {
+	return c.QueryContext(context.Background(), q)
+}
+
+// QueryContext is like Query, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) QueryContext(ctx context.Context, q *IndexQuery) (*Keyres, error) {
+	if len(q.args) == 0 {
+		return nil, errors.New("riak: index query needs Equal or Range")
+	}
+	path := ipath(c.btype, q.bucket, q.index, q.args...)
+	if v := q.query(); len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+	res, err := c.do(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
 	if res.StatusCode != 200 {
 		res.Body.Close()
-		return nil, statusCode(res.StatusCode)
+		switch res.StatusCode {
+		case 400:
+			return nil, ErrBadRequest
+		case 503:
+			return nil, ErrTimeout
+		default:
+			return nil, statusCode(res.StatusCode)
+		}
 	}
 	kr := new(Keyres)
 	kr.Keys = make([]string, 0, 1)
@@ -28,19 +146,120 @@ func (c *Client) IndexLookup(bucket string, index string, value string) (*Keyres
 	return kr, err
 }
 
-// /buckets/[bucket]/index/[index]/?...
-func ipath(bucket string, index string, value string) string {
+// /buckets/[bucket]/index/[index]/[value]
+// or /buckets/[bucket]/index/[index]/[start]/[end],
+// prefixed with /types/[type] for non-default types
+func ipath(btype string, bucket string, index string, args ...string) string {
 	var stack [80]byte
 	buf := bytes.NewBuffer(stack[0:0])
-	buf.WriteString("/buckets/")
-	buf.WriteString(bucket)
+	buf.WriteString(bucketPath(btype, bucket))
 	buf.WriteString("/index/")
 	buf.WriteString(index)
-	buf.WriteByte('/')
-	buf.WriteString(value)
+	for _, a := range args {
+		buf.WriteByte('/')
+		buf.WriteString(a)
+	}
 	return buf.String()
 }
 
+// Keyres is the result of a secondary index query
 type Keyres struct {
-	Keys []string `json:"keys"`
+	Keys         []string    `json:"keys"`
+	Results      []IndexTerm `json:"results"`      // only with ReturnTerms
+	Continuation string      `json:"continuation"` // empty on the last page
+}
+
+// IndexTerm is a key and the index term that it matched
+type IndexTerm struct {
+	Term string
+	Key  string
+}
+
+// riak sends these as {"term":"key"}
+func (t *IndexTerm) UnmarshalJSON(b []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for term, key := range m {
+		t.Term, t.Key = term, key
+	}
+	return nil
 }
+
+// IndexIter iterates over the results of a secondary index
+// query one page at a time, following continuations until
+// the results are exhausted.
+//
+//	it := c.QueryIter(NewIndexQuery("users", "age_int").Range("18", "65"))
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type IndexIter struct {
+	c    *Client
+	ctx  context.Context
+	q    IndexQuery
+	page []IndexTerm
+	cur  IndexTerm
+	last bool
+	err  error
+}
+
+// QueryIter returns an iterator over the results of 'q'. If 'q'
+// doesn't set MaxResults, pages of 1000 results are fetched.
+func (c *Client) QueryIter(q *IndexQuery) *IndexIter {
+	return c.QueryIterContext(context.Background(), q)
+}
+
+// QueryIterContext is like QueryIter, but the iterator stops
+// with the context's error if 'ctx' is done.
+func (c *Client) QueryIterContext(ctx context.Context, q *IndexQuery) *IndexIter {
+	it := &IndexIter{c: c, ctx: ctx, q: *q}
+	if it.q.maxResults <= 0 {
+		it.q.maxResults = 1000
+	}
+	return it
+}
+
+// Next advances the iterator, returning false
+// when there are no more results or on error.
+func (it *IndexIter) Next() bool {
+	for len(it.page) == 0 {
+		if it.last || it.err != nil {
+			return false
+		}
+		kr, err := it.c.QueryContext(it.ctx, &it.q)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if kr.Results != nil {
+			it.page = kr.Results
+		} else {
+			it.page = make([]IndexTerm, len(kr.Keys))
+			for i, k := range kr.Keys {
+				it.page[i].Key = k
+			}
+		}
+		// an empty page that doesn't move the continuation
+		// would have us fetch the same page forever
+		stuck := len(it.page) == 0 && kr.Continuation == it.q.continuation
+		it.q.continuation = kr.Continuation
+		it.last = kr.Continuation == "" || stuck
+	}
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Key returns the current key
+func (it *IndexIter) Key() string { return it.cur.Key }
+
+// Term returns the index term of the current
+// key if the query used ReturnTerms
+func (it *IndexIter) Term() string { return it.cur.Term }
+
+// Err returns the error that stopped the iterator, if any
+func (it *IndexIter) Err() error { return it.err }
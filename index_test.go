@@ -1,5 +1,3 @@
-// +build riak
-
 package riak
 
 import (
@@ -18,7 +16,7 @@ func TestIndexLookup(t *testing.T) {
 
 	objA.AddIndex("USERNAME_bin", "bob123")
 
-	c := newtestclient("http://localhost:8098")
+	c := newtestclient(testHost)
 	err := c.Store(objA, nil)
 	if err != nil {
 		dump(t, c, err)
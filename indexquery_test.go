@@ -0,0 +1,112 @@
+package riak
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestIndexQueryPath(t *testing.T) {
+	q := NewIndexQuery("users", "age_int").Range("18", "65").MaxResults(10).ReturnTerms().TermRegex("^1")
+	path := ipath("", q.bucket, q.index, q.args...) + "?" + q.query().Encode()
+	want := "/buckets/users/index/age_int/18/65?max_results=10&return_terms=true&term_regex=%5E1"
+	if path != want {
+		t.Errorf("expected %q; got %q", want, path)
+	}
+	if p := ipath("", "users", BucketIndex, "users"); p != "/buckets/users/index/$bucket/users" {
+		t.Errorf("unexpected $bucket path %q", p)
+	}
+}
+
+func TestQueryIter(t *testing.T) {
+	// five keys with terms 1 through 5
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/buckets/users/index/age_int/1/5" {
+			w.WriteHeader(400)
+			return
+		}
+		q := r.URL.Query()
+		max, _ := strconv.Atoi(q.Get("max_results"))
+		start, _ := strconv.Atoi(q.Get("continuation"))
+		out := map[string]interface{}{}
+		var results []map[string]string
+		var i int
+		for i = start; i < 5 && i < start+max; i++ {
+			results = append(results, map[string]string{strconv.Itoa(i + 1): "user" + strconv.Itoa(i+1)})
+		}
+		out["results"] = results
+		if i < 5 {
+			out["continuation"] = strconv.Itoa(i)
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	it := c.QueryIter(NewIndexQuery("users", "age_int").Range("1", "5").ReturnTerms().MaxResults(2))
+	n := 0
+	for it.Next() {
+		n++
+		if it.Term() != strconv.Itoa(n) || it.Key() != "user"+strconv.Itoa(n) {
+			t.Errorf("result %d: got term %q key %q", n, it.Term(), it.Key())
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if n != 5 {
+		t.Errorf("expected 5 results; got %d", n)
+	}
+
+	kr, err := c.Query(NewIndexQuery("users", "age_int").Range("1", "5").ReturnTerms().MaxResults(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kr.Results) != 2 || kr.Continuation != "2" {
+		t.Errorf("unexpected first page %+v", kr)
+	}
+
+	_, err = c.Query(NewIndexQuery("users", "age_int").Equal("1"))
+	if err != ErrBadRequest {
+		t.Errorf("expected ErrBadRequest; got %v", err)
+	}
+}
+
+func TestQueryIterEmptyPages(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		out := map[string]interface{}{"keys": []string{}}
+		switch r.URL.Query().Get("continuation") {
+		case "":
+			// empty pages that advance are followed
+			out["continuation"] = "a"
+		case "a":
+			out["keys"] = []string{"alice"}
+			out["continuation"] = "b"
+		default:
+			// the same empty page forever
+			out["continuation"] = "b"
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	it := c.QueryIter(NewIndexQuery("users", "name_bin").Equal("x"))
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if len(keys) != 1 || keys[0] != "alice" {
+		t.Errorf("unexpected keys %q", keys)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests; got %d", requests)
+	}
+}
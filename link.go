@@ -2,31 +2,128 @@ package riak
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"mime"
 	"mime/multipart"
-	"net/http"
+	"net/textproto"
 	"strings"
 )
 
+// riak's link walking resource predates bucket types
+var errLinkWalkType = errors.New("riak: link walking is only supported in the default bucket type")
+
 // FollowMultiLink follows one of the object's named links, returning
 // one or many Objects.
 func (c *Client) FollowMultiLink(o *Object, name string) ([]*Object, error) {
+	return c.FollowMultiLinkContext(context.Background(), o, name)
+}
+
+// FollowMultiLinkContext is like FollowMultiLink, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FollowMultiLinkContext(ctx context.Context, o *Object, name string) ([]*Object, error) {
 	link, ok := o.Links[name]
 	if !ok {
 		return nil, errors.New("Link name doesn't exist for this object.")
 	}
-	path := linkpath(o, name, link)
+	if o.BucketType != "" {
+		return nil, errLinkWalkType
+	}
+	groups, err := c.WalkLinksContext(ctx, NewLinkWalk(o.Bucket, o.Key).Step(link.Bucket, name, true))
+	if err != nil {
+		return nil, err
+	}
+	var objs []*Object
+	for _, g := range groups {
+		objs = append(objs, g...)
+	}
+	return objs, nil
+}
+
+// LinkWalk is a multi-step link walk starting at one object.
+//
+//	// the posts of bob's friends, and the friends themselves
+//	w := NewLinkWalk("people", "bob").
+//		Step("people", "friend", true).
+//		Step("posts", "post", true)
+type LinkWalk struct {
+	bucket string
+	key    string
+	steps  []linkStep
+}
+
+type linkStep struct {
+	bucket string
+	tag    string
+	keep   bool
+}
+
+// NewLinkWalk starts a link walk at bucket/key
+func NewLinkWalk(bucket string, key string) *LinkWalk {
+	return &LinkWalk{bucket: bucket, key: key}
+}
+
+// Step follows the links with 'tag' that point into 'bucket'
+// from each object reached by the previous step. Either may
+// be empty to match any bucket or tag. If 'keep' is set, the
+// objects reached by this step are returned.
+func (w *LinkWalk) Step(bucket string, tag string, keep bool) *LinkWalk {
+	w.steps = append(w.steps, linkStep{bucket: bucket, tag: tag, keep: keep})
+	return w
+}
+
+// /riak/bucket/key/bucket,tag,keep/...
+func (w *LinkWalk) path() string {
+	var stack [64]byte
+	buf := bytes.NewBuffer(stack[0:0])
+	buf.WriteString("/riak/")
+	buf.WriteString(w.bucket)
+	buf.WriteByte('/')
+	buf.WriteString(w.key)
+	for _, s := range w.steps {
+		buf.WriteByte('/')
+		if s.bucket != "" {
+			buf.WriteString(s.bucket)
+		} else {
+			buf.WriteByte('_')
+		}
+		buf.WriteByte(',')
+		if s.tag != "" {
+			buf.WriteString(s.tag)
+		} else {
+			buf.WriteByte('_')
+		}
+		if s.keep {
+			buf.WriteString(",1")
+		} else {
+			buf.WriteString(",0")
+		}
+	}
+	return buf.String()
+}
 
-	req, err := http.NewRequest("GET", c.host+path, nil)
-	req.Header.Set("X-Riak-ClientId", c.id)
+// WalkLinks performs a link walk. The result has one group
+// of objects for each step that was kept, in order. Link
+// walking is only supported over HTTP, and only in the
+// default bucket type.
+func (c *Client) WalkLinks(w *LinkWalk) ([][]*Object, error) {
+	return c.WalkLinksContext(context.Background(), w)
+}
 
-	res, err := c.cl.Do(req)
+// WalkLinksContext is like WalkLinks, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) WalkLinksContext(ctx context.Context, w *LinkWalk) ([][]*Object, error) {
+	if len(w.steps) == 0 {
+		return nil, errors.New("riak: link walk has no steps")
+	}
+	if c.btype != "" {
+		return nil, errLinkWalkType
+	}
+	res, err := c.do(ctx, "GET", w.path(), nil)
 	if err != nil {
 		return nil, err
 	}
-
 	if res.StatusCode != 200 {
 		res.Body.Close()
 		switch res.StatusCode {
@@ -38,72 +135,90 @@ func (c *Client) FollowMultiLink(o *Object, name string) ([]*Object, error) {
 			return nil, statusCode(res.StatusCode)
 		}
 	}
-	var objs []*Object
-	mtype, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	groups, err := parseWalk(res.Header.Get("Content-Type"), res.Body)
+	res.Body.Close()
 	if err != nil {
+		for _, g := range groups {
+			for _, o := range g {
+				Release(o)
+			}
+		}
 		return nil, err
 	}
+	return groups, nil
+}
 
-	var deferError error
-	if strings.HasPrefix(mtype, "multipart/") {
-		mpr := multipart.NewReader(res.Body, params["boundary"])
+// The response to a link walk is a multipart/mixed
+// body with one part per kept step, each of which is
+// itself multipart/mixed with one part per object.
+func parseWalk(ctype string, body io.Reader) ([][]*Object, error) {
+	mpr, err := multipartReader(ctype, body)
+	if err != nil {
+		return nil, err
+	}
+	var groups [][]*Object
+	for {
+		step, err := mpr.NextPart()
+		if err == io.EOF {
+			return groups, nil
+		} else if err != nil {
+			return groups, err
+		}
+		objs := []*Object{}
+		groups = append(groups, objs)
+		inner, err := multipartReader(step.Header.Get("Content-Type"), step)
+		if err != nil {
+			return groups, err
+		}
 		for {
-			part, err := mpr.NextPart()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				res.Body.Close()
-				return objs, err
+			part, err := inner.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return groups, err
 			}
-
 			o := newObj()
+			o.Bucket, o.Key = locationKey(part.Header)
 			err = o.fromResponse(part.Header, part)
+			objs = append(objs, o)
+			groups[len(groups)-1] = objs
 			if err != nil {
-				deferError = err
-				continue
+				return groups, err
 			}
 		}
-	} else {
-		o := newObj()
-		err = o.fromResponse(res.Header, res.Body)
-		objs = append(objs, o)
-		return objs, err
 	}
-	return objs, deferError
 }
 
-func linkpath(o *Object, name string, link Link) string {
-	var stack [64]byte
-	buf := bytes.NewBuffer(stack[0:0])
-	buf.WriteString("/riak/")
-	buf.WriteString(o.Bucket)
-	buf.WriteByte('/')
-	buf.WriteString(o.Key)
-	buf.WriteByte('/')
-
-	if link.Bucket != "" {
-		buf.WriteString(link.Bucket)
-	} else {
-		buf.WriteByte('_')
+func multipartReader(ctype string, body io.Reader) (*multipart.Reader, error) {
+	mtype, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return nil, err
 	}
-	buf.WriteByte(',')
-
-	buf.WriteString(name)
-	buf.WriteByte(',')
+	if !strings.HasPrefix(mtype, "multipart/") {
+		return nil, errors.New("riak: expected a multipart response; got " + mtype)
+	}
+	return multipart.NewReader(body, params["boundary"]), nil
+}
 
-	if link.Key != "" {
-		buf.WriteString(link.Key)
-	} else {
-		buf.WriteByte('_')
+// get the bucket and key out of 'Location: /riak/bucket/key'
+func locationKey(hdr textproto.MIMEHeader) (bucket string, key string) {
+	loc := strings.Split(strings.Trim(hdr.Get("Location"), "/"), "/")
+	if len(loc) < 2 {
+		return "", ""
 	}
-	return buf.String()
+	return loc[len(loc)-2], loc[len(loc)-1]
 }
 
 // FetchLink follows an object link that links to one object.
 // This works analagously to Fetch()ing the object at the named link. 'opts'
 // are passed directly to Fetch. The link must have both the bucket and key fields defined.
 func (c *Client) FetchLink(o *Object, name string, opts map[string]string) (*Object, error) {
+	return c.FetchLinkContext(context.Background(), o, name, opts)
+}
+
+// FetchLinkContext is like FetchLink, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FetchLinkContext(ctx context.Context, o *Object, name string, opts map[string]string) (*Object, error) {
 	link, ok := o.Links[name]
 	if !ok {
 		return nil, errors.New("Link name doesn't exist for this object.")
@@ -113,5 +228,5 @@ func (c *Client) FetchLink(o *Object, name string, opts map[string]string) (*Obj
 		return nil, errors.New("Link doesn't link to one object.")
 	}
 
-	return c.Fetch(link.Bucket, link.Key, opts)
+	return c.FetchContext(ctx, link.Bucket, link.Key, opts)
 }
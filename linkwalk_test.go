@@ -1,12 +1,32 @@
-// +build riak
-
 package riak
 
 import (
 	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
+// two kept steps: one friend, then two posts
+const walkBody = "\r\n--outer\r\n" +
+	"Content-Type: multipart/mixed; boundary=step1\r\n\r\n" +
+	"\r\n--step1\r\n" +
+	"X-Riak-Vclock: aliceclock\r\nLocation: /riak/people/alice\r\nContent-Type: application/json\r\n" +
+	"Link: </riak/posts/p1>; riaktag=\"post\", </riak/posts/p2>; riaktag=\"post\"\r\n\r\n" +
+	"{\"name\":\"alice\"}" +
+	"\r\n--step1--\r\n" +
+	"\r\n--outer\r\n" +
+	"Content-Type: multipart/mixed; boundary=step2\r\n\r\n" +
+	"\r\n--step2\r\n" +
+	"Location: /riak/posts/p1\r\nContent-Type: text/plain\r\nX-Riak-Meta-Author: alice\r\n\r\n" +
+	"first" +
+	"\r\n--step2\r\n" +
+	"Location: /riak/posts/p2\r\nContent-Type: text/plain\r\n\r\n" +
+	"second" +
+	"\r\n--step2--\r\n" +
+	"\r\n--outer--\r\n"
+
 func TestLinkWalk(t *testing.T) {
 	/*
 	   - Create an object
@@ -22,7 +42,7 @@ func TestLinkWalk(t *testing.T) {
 		Body:   bodyA,
 	}
 
-	c := newtestclient("http://localhost:8098")
+	c := newtestclient(testHost)
 	err := c.Store(objA, nil)
 	if err != nil {
 		dump(t, c, err)
@@ -62,3 +82,75 @@ func TestLinkWalk(t *testing.T) {
 	Release(objB)
 	Release(child)
 }
+
+func TestLinkWalkPath(t *testing.T) {
+	w := NewLinkWalk("people", "bob").
+		Step("people", "friend", false).
+		Step("", "", true)
+	if p := w.path(); p != "/riak/people/bob/people,friend,0/_,_,1" {
+		t.Errorf("path is %q", p)
+	}
+}
+
+func TestWalkLinks(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "multipart/mixed; boundary=outer")
+		io.WriteString(w, walkBody)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	groups, err := c.WalkLinks(NewLinkWalk("people", "bob").
+		Step("people", "friend", true).
+		Step("posts", "post", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/riak/people/bob/people,friend,1/posts,post,1" {
+		t.Errorf("requested %q", path)
+	}
+	if len(groups) != 2 || len(groups[0]) != 1 || len(groups[1]) != 2 {
+		t.Fatalf("bad groups: %v", groups)
+	}
+	alice := groups[0][0]
+	if alice.Bucket != "people" || alice.Key != "alice" || alice.Vclock != "aliceclock" {
+		t.Errorf("alice is %s/%s (vclock %q)", alice.Bucket, alice.Key, alice.Vclock)
+	}
+	if alice.Body.String() != `{"name":"alice"}` {
+		t.Errorf("alice's body is %q", alice.Body.String())
+	}
+	if links := alice.LinksTagged("post"); len(links) != 2 ||
+		links[0] != (Link{Bucket: "posts", Key: "p1"}) || links[1] != (Link{Bucket: "posts", Key: "p2"}) {
+		t.Errorf("alice's post links are %v", links)
+	}
+	p1, p2 := groups[1][0], groups[1][1]
+	if p1.Key != "p1" || p1.Body.String() != "first" || p1.Meta["Author"] != "alice" {
+		t.Errorf("bad first post: %s %q %v", p1.Key, p1.Body.String(), p1.Meta)
+	}
+	if p2.Bucket != "posts" || p2.Key != "p2" || p2.Body.String() != "second" {
+		t.Errorf("bad second post: %s/%s %q", p2.Bucket, p2.Key, p2.Body.String())
+	}
+
+	// FollowMultiLink flattens a one-step walk
+	objs, err := c.FollowMultiLink(alice, "post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/riak/people/alice/posts,post,1" {
+		t.Errorf("requested %q", path)
+	}
+	if len(objs) != 3 {
+		t.Errorf("FollowMultiLink returned %d objects", len(objs))
+	}
+
+	// the walk resource doesn't know about bucket types
+	if _, err := c.BucketType("users").WalkLinks(NewLinkWalk("people", "bob").Step("", "", true)); err != errLinkWalkType {
+		t.Errorf("expected errLinkWalkType; got %v", err)
+	}
+	alice.BucketType = "users"
+	if _, err := c.FollowMultiLink(alice, "post"); err != errLinkWalkType {
+		t.Errorf("expected errLinkWalkType; got %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"time"
+)
+
+// Function is a map or reduce function. Create
+// one with JSSource, JSNamed or Erlang.
+type Function struct {
+	language string
+	source   string // javascript source
+	name     string // named javascript function
+	module   string // erlang
+	function string // erlang
+}
+
+// JSSource is a javascript function given as source code,
+// e.g. "function(v) { return [v.values[0].data]; }"
+func JSSource(src string) Function {
+	return Function{language: "javascript", source: src}
+}
+
+// JSNamed is a javascript function that is
+// built in to riak, e.g. "Riak.mapValuesJson"
+func JSNamed(name string) Function {
+	return Function{language: "javascript", name: name}
+}
+
+// Erlang is an erlang function, e.g. Erlang("riak_kv_mapreduce", "map_object_value")
+func Erlang(module string, function string) Function {
+	return Function{language: "erlang", module: module, function: function}
+}
+
+func (f Function) phase(keep bool, arg interface{}) map[string]interface{} {
+	p := map[string]interface{}{
+		"language": f.language,
+		"keep":     keep,
+	}
+	switch {
+	case f.source != "":
+		p["source"] = f.source
+	case f.name != "":
+		p["name"] = f.name
+	default:
+		p["module"] = f.module
+		p["function"] = f.function
+	}
+	if arg != nil {
+		p["arg"] = arg
+	}
+	return p
+}
+
+// MapReduce is a MapReduce job. Create one with NewMapReduce,
+// give it inputs, add phases, and run it with Client.MapReduce.
+//
+//	job := NewMapReduce().Bucket("orders").
+//		Map(JSNamed("Riak.mapValuesJson"), false, nil).
+//		Reduce(Erlang("riak_kv_mapreduce", "reduce_count_inputs"), true, nil)
+type MapReduce struct {
+	inputs  interface{}
+	keys    [][]interface{}
+	query   []map[string]interface{}
+	timeout time.Duration
+}
+
+// NewMapReduce creates an empty job
+func NewMapReduce() *MapReduce { return &MapReduce{} }
+
+// Bucket uses every object in 'bucket' as input
+func (m *MapReduce) Bucket(bucket string) *MapReduce {
+	m.inputs = bucket
+	return m
+}
+
+// AddKey adds one object to the job's inputs.
+// It may be called many times.
+func (m *MapReduce) AddKey(bucket string, key string) *MapReduce {
+	m.keys = append(m.keys, []interface{}{bucket, key})
+	return m
+}
+
+// AddKeyData adds one object to the job's inputs, along
+// with data that is passed to the first map phase.
+func (m *MapReduce) AddKeyData(bucket string, key string, data interface{}) *MapReduce {
+	m.keys = append(m.keys, []interface{}{bucket, key, data})
+	return m
+}
+
+// KeyFilter uses the objects in 'bucket' whose keys pass
+// 'filters' as input. Each filter is a list such as
+// []interface{}{"tokenize", "-", 1} or []interface{}{"eq", "2013"}.
+func (m *MapReduce) KeyFilter(bucket string, filters ...[]interface{}) *MapReduce {
+	m.inputs = map[string]interface{}{"bucket": bucket, "key_filters": filters}
+	return m
+}
+
+// Index uses the objects in 'bucket' with 'value' for 'index' as input
+func (m *MapReduce) Index(bucket string, index string, value string) *MapReduce {
+	m.inputs = map[string]interface{}{"bucket": bucket, "index": index, "key": value}
+	return m
+}
+
+// IndexRange uses the objects in 'bucket' whose values
+// for 'index' are between 'start' and 'end' as input
+func (m *MapReduce) IndexRange(bucket string, index string, start string, end string) *MapReduce {
+	m.inputs = map[string]interface{}{"bucket": bucket, "index": index, "start": start, "end": end}
+	return m
+}
+
+// Map adds a map phase. If 'keep' is set, the results
+// of the phase are returned. 'arg' is passed to the
+// function if it isn't nil.
+func (m *MapReduce) Map(f Function, keep bool, arg interface{}) *MapReduce {
+	m.query = append(m.query, map[string]interface{}{"map": f.phase(keep, arg)})
+	return m
+}
+
+// Reduce adds a reduce phase. If 'keep' is set, the results
+// of the phase are returned. 'arg' is passed to the
+// function if it isn't nil.
+func (m *MapReduce) Reduce(f Function, keep bool, arg interface{}) *MapReduce {
+	m.query = append(m.query, map[string]interface{}{"reduce": f.phase(keep, arg)})
+	return m
+}
+
+// Link adds a link phase that follows links to 'bucket'
+// with 'tag'. Either may be "_" to match anything.
+func (m *MapReduce) Link(bucket string, tag string, keep bool) *MapReduce {
+	m.query = append(m.query, map[string]interface{}{"link": map[string]interface{}{
+		"bucket": bucket,
+		"tag":    tag,
+		"keep":   keep,
+	}})
+	return m
+}
+
+// Timeout sets the job's server-side timeout
+func (m *MapReduce) Timeout(d time.Duration) *MapReduce {
+	m.timeout = d
+	return m
+}
+
+// MarshalJSON returns the job as riak expects it
+func (m *MapReduce) MarshalJSON() ([]byte, error) {
+	job := map[string]interface{}{}
+	switch {
+	case m.keys != nil:
+		job["inputs"] = m.keys
+	case m.inputs != nil:
+		job["inputs"] = m.inputs
+	default:
+		return nil, errors.New("riak: MapReduce job has no inputs")
+	}
+	query := m.query
+	if query == nil {
+		query = []map[string]interface{}{}
+	}
+	job["query"] = query
+	if m.timeout > 0 {
+		job["timeout"] = int64(m.timeout / time.Millisecond)
+	}
+	return json.Marshal(job)
+}
+
+// ErrMapReduce is returned when riak
+// fails to run a MapReduce job
+type ErrMapReduce struct {
+	Code int    // HTTP status code
+	Msg  string // riak's description of the error
+}
+
+func (e *ErrMapReduce) Error() string {
+	return "riak: mapreduce failed: " + e.Msg
+}
+
+func (c *Client) postJob(ctx context.Context, job *MapReduce, chunked bool) (io.ReadCloser, string, error) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return nil, "", err
+	}
+	path := "/mapred"
+	if chunked {
+		path += "?chunked=true"
+	}
+	req, err := c.newreq(ctx, "POST", path, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.send(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.StatusCode != 200 {
+		msg, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode == 503 {
+			return nil, "", ErrTimeout
+		}
+		return nil, "", &ErrMapReduce{Code: res.StatusCode, Msg: strings.TrimSpace(string(msg))}
+	}
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
+
+// MapReduce runs a job and decodes its results into 'out'. If one
+// phase is kept, the results are a list; if several phases are kept,
+// they are a list with one list of results per phase.
+func (c *Client) MapReduce(job *MapReduce, out interface{}) error {
+	return c.MapReduceContext(context.Background(), job, out)
+}
+
+// MapReduceContext is like MapReduce, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) MapReduceContext(ctx context.Context, job *MapReduce, out interface{}) error {
+	body, _, err := c.postJob(ctx, job, false)
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(body).Decode(out)
+	body.Close()
+	return err
+}
+
+// MapReduceStream iterates over the results of a
+// MapReduce job as riak sends them (chunked=true).
+// Always call Close when done with a stream.
+type MapReduceStream struct {
+	body  io.ReadCloser
+	mpr   *multipart.Reader
+	phase int
+	data  json.RawMessage
+	done  bool
+	err   error
+}
+
+// MapReduceStream runs a job and returns a stream of
+// its results, which arrive as each phase produces them.
+func (c *Client) MapReduceStream(job *MapReduce) (*MapReduceStream, error) {
+	return c.MapReduceStreamContext(context.Background(), job)
+}
+
+// MapReduceStreamContext is like MapReduceStream, but the
+// stream ends with the context's error if 'ctx' is done.
+func (c *Client) MapReduceStreamContext(ctx context.Context, job *MapReduce) (*MapReduceStream, error) {
+	body, ctype, err := c.postJob(ctx, job, true)
+	if err != nil {
+		return nil, err
+	}
+	_, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &MapReduceStream{
+		body: body,
+		mpr:  multipart.NewReader(body, params["boundary"]),
+	}, nil
+}
+
+// Next advances to the next chunk of results, returning
+// false when there are no more or an error occurred.
+func (s *MapReduceStream) Next() bool {
+	if s.done {
+		return false
+	}
+	part, err := s.mpr.NextPart()
+	if err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		s.Close()
+		return false
+	}
+	var chunk struct {
+		Phase int             `json:"phase"`
+		Data  json.RawMessage `json:"data"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(part).Decode(&chunk); err != nil {
+		s.err = err
+		s.Close()
+		return false
+	}
+	if chunk.Error != nil {
+		s.err = &ErrMapReduce{Code: 200, Msg: string(chunk.Error)}
+		s.Close()
+		return false
+	}
+	s.phase, s.data = chunk.Phase, chunk.Data
+	return true
+}
+
+// Phase returns the index of the phase that
+// produced the current chunk of results
+func (s *MapReduceStream) Phase() int { return s.phase }
+
+// Decode decodes the current chunk of results,
+// which is a list, into 'v'
+func (s *MapReduceStream) Decode(v interface{}) error {
+	return json.Unmarshal(s.data, v)
+}
+
+// Err returns the error that ended the stream, if any
+func (s *MapReduceStream) Err() error { return s.err }
+
+// Close stops the stream and releases the connection
+func (s *MapReduceStream) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	return s.body.Close()
+}
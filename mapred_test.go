@@ -0,0 +1,115 @@
+package riak
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMapReduceJSON(t *testing.T) {
+	job := NewMapReduce().
+		IndexRange("orders", "date_bin", "2013", "2014").
+		Map(JSSource("function(v) { return [1]; }"), false, nil).
+		Link("_", "customer", false).
+		Reduce(Erlang("riak_kv_mapreduce", "reduce_sum"), true, map[string]int{"limit": 5}).
+		Timeout(10 * time.Second)
+	body, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want interface{}
+	json.Unmarshal(body, &got)
+	json.Unmarshal([]byte(`{
+		"inputs": {"bucket": "orders", "index": "date_bin", "start": "2013", "end": "2014"},
+		"query": [
+			{"map": {"language": "javascript", "source": "function(v) { return [1]; }", "keep": false}},
+			{"link": {"bucket": "_", "tag": "customer", "keep": false}},
+			{"reduce": {"language": "erlang", "module": "riak_kv_mapreduce", "function": "reduce_sum", "keep": true, "arg": {"limit": 5}}}
+		],
+		"timeout": 10000
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected job %s", body)
+	}
+
+	body, _ = json.Marshal(NewMapReduce().AddKey("b", "k1").AddKeyData("b", "k2", 3).Map(JSNamed("Riak.mapValuesJson"), true, nil))
+	json.Unmarshal(body, &got)
+	json.Unmarshal([]byte(`{
+		"inputs": [["b", "k1"], ["b", "k2", 3]],
+		"query": [{"map": {"language": "javascript", "name": "Riak.mapValuesJson", "keep": true}}]
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected job %s", body)
+	}
+
+	if _, err := json.Marshal(NewMapReduce()); err == nil {
+		t.Error("expected an error for a job without inputs")
+	}
+}
+
+func TestMapReduceRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mapred" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(400)
+			return
+		}
+		var job map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil || job["inputs"] == "bad" {
+			w.WriteHeader(500)
+			io.WriteString(w, `{"error":"bad_json"}`)
+			return
+		}
+		if r.URL.Query().Get("chunked") != "true" {
+			io.WriteString(w, `[1,2,3]`)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/mixed; boundary=mr")
+		io.WriteString(w, "\r\n--mr\r\nContent-Type: application/json\r\n\r\n"+
+			`{"phase":0,"data":[1,2]}`+
+			"\r\n--mr\r\nContent-Type: application/json\r\n\r\n"+
+			`{"phase":1,"data":[3]}`+
+			"\r\n--mr--\r\n")
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	var out []int
+	job := NewMapReduce().Bucket("testing").Map(JSNamed("Riak.mapValuesJson"), true, nil)
+	if err := c.MapReduce(job, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, []int{1, 2, 3}) {
+		t.Errorf("unexpected results %v", out)
+	}
+
+	s, err := c.MapReduceStream(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var phases []int
+	out = out[:0]
+	for s.Next() {
+		var chunk []int
+		if err := s.Decode(&chunk); err != nil {
+			t.Fatal(err)
+		}
+		phases = append(phases, s.Phase())
+		out = append(out, chunk...)
+	}
+	if s.Err() != nil {
+		t.Fatal(s.Err())
+	}
+	if !reflect.DeepEqual(phases, []int{0, 1}) || !reflect.DeepEqual(out, []int{1, 2, 3}) {
+		t.Errorf("unexpected streamed results %v from phases %v", out, phases)
+	}
+
+	err = c.MapReduce(NewMapReduce().Bucket("bad"), &out)
+	if merr, ok := err.(*ErrMapReduce); !ok || merr.Code != 500 {
+		t.Errorf("expected *ErrMapReduce; got %v", err)
+	}
+}
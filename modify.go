@@ -0,0 +1,145 @@
+package riak
+
+import (
+	"context"
+	"time"
+)
+
+// ModifyOptions control Modify. The zero value is usable.
+type ModifyOptions struct {
+	// MaxAttempts is the number of times the object is
+	// fetched and modified before Modify gives up; it
+	// defaults to 5.
+	MaxAttempts int
+
+	// Resolver resolves siblings that are fetched. It
+	// defaults to the resolver registered for the bucket
+	// with WithResolver. Without one, siblings are an error.
+	Resolver Resolver
+
+	// FetchOpts and StoreOpts are passed to
+	// Fetch and Merge respectively, e.g. {"r": "all"}
+	FetchOpts map[string]string
+	StoreOpts map[string]string
+}
+
+// Modify performs a read-modify-write of the object at bucket/key.
+// It fetches the object, passes it to 'f', and stores the result
+// only if the object hasn't changed in the meantime. If it has,
+// the object is fetched and modified again, up to opts.MaxAttempts
+// times, after which a *RetryError is returned. If the write goes
+// through but creates siblings, 'f' isn't applied again: the
+// siblings are resolved with the resolver, or returned as
+// *ErrMultipleVclocks without one. If the object doesn't exist, 'f' is
+// passed a new object with an empty body, which is only created
+// if it still doesn't exist when it's stored. If 'f' returns an
+// error, Modify returns it without storing anything. 'f' may be
+// called more than once, so it shouldn't have side effects.
+// 'opts' may be nil.
+//
+//	o, err := c.Modify("counters", "visits", func(o *Object) error {
+//		n, _ := strconv.Atoi(o.Body.String())
+//		o.Body.Reset()
+//		o.Body.WriteString(strconv.Itoa(n + 1))
+//		return nil
+//	}, nil)
+func (c *Client) Modify(bucket string, key string, f func(o *Object) error, opts *ModifyOptions) (*Object, error) {
+	return c.ModifyContext(context.Background(), bucket, key, f, opts)
+}
+
+// ModifyContext is like Modify, but the requests are
+// abandoned if 'ctx' is done before they complete.
+func (c *Client) ModifyContext(ctx context.Context, bucket string, key string, f func(o *Object) error, opts *ModifyOptions) (*Object, error) {
+	if opts == nil {
+		opts = &ModifyOptions{}
+	}
+	max := opts.MaxAttempts
+	if max <= 0 {
+		max = 5
+	}
+	r := opts.Resolver
+	if r == nil {
+		r = c.resolver(bucket)
+	}
+	// delays between attempts follow the client's
+	// retry policy, or its defaults
+	p := c.retry
+	if p == nil {
+		p = &RetryPolicy{}
+	}
+
+	var errs []error
+	for n := 1; ; n++ {
+		o, err := c.modifyOnce(ctx, bucket, key, f, opts, r)
+		if err == nil {
+			return o, nil
+		}
+		if err != ErrModified {
+			return nil, err
+		}
+		errs = append(errs, err)
+		if n >= max {
+			return nil, &RetryError{Attempts: errs}
+		}
+		t := time.NewTimer(p.backoff(n))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// fetch, modify and store once
+func (c *Client) modifyOnce(ctx context.Context, bucket string, key string, f func(*Object) error, opts *ModifyOptions, r Resolver) (*Object, error) {
+	var o *Object
+	sibs, err := c.FetchSiblingsContext(ctx, bucket, key, opts.FetchOpts)
+	switch {
+	case err == ErrNotFound:
+		o = newObj()
+		o.Bucket, o.Key, o.BucketType = bucket, key, c.btype
+	case err != nil:
+		return nil, err
+	case len(sibs) == 1:
+		o = sibs[0]
+	case r == nil:
+		err := &ErrMultipleVclocks{Vclocks: siblingTags(sibs)}
+		for _, s := range sibs {
+			Release(s)
+		}
+		return nil, err
+	default:
+		if o, err = resolveSiblings(sibs, r); err != nil {
+			return nil, err
+		}
+		// siblings don't have a single etag; the
+		// vclock makes the write resolve them
+		o.eTag = ""
+	}
+
+	if err := f(o); err != nil {
+		Release(o)
+		return nil, err
+	}
+
+	switch {
+	case o.eTag != "":
+		err = c.put(ctx, o, opts.StoreOpts, "If-Match", o.eTag)
+	case o.Vclock != "":
+		err = c.put(ctx, o, opts.StoreOpts, "", "")
+	default:
+		// don't overwrite an object created in the meantime
+		err = c.put(ctx, o, opts.StoreOpts, "If-None-Match", "*")
+	}
+	if err != nil {
+		Release(o)
+		if _, ok := err.(*ErrMultipleVclocks); ok && r != nil {
+			// the write went through, but created siblings;
+			// resolve them rather than applying 'f' again
+			return c.FetchResolveContext(ctx, bucket, key, opts.FetchOpts, r)
+		}
+		return nil, err
+	}
+	return o, nil
+}
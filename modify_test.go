@@ -0,0 +1,162 @@
+package riak
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philhofer/riak/riaktest"
+)
+
+func increment(o *Object) error {
+	n, _ := strconv.Atoi(o.Body.String())
+	o.Body.Reset()
+	o.Body.WriteString(strconv.Itoa(n + 1))
+	return nil
+}
+
+func TestModify(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient", WithRetry(&RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}))
+
+	// created if it doesn't exist
+	o, err := c.Modify("counts", "visits", increment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Body.String() != "1" || o.Vclock == "" {
+		t.Errorf("bad object %q %q", o.Body, o.Vclock)
+	}
+
+	// concurrent modifications all apply
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Modify("counts", "visits", increment, &ModifyOptions{MaxAttempts: 100})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	o, err = c.Fetch("counts", "visits", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Body.String() != "11" {
+		t.Errorf("expected 11; got %s", o.Body)
+	}
+
+	// errors from 'f' abort
+	oops := errors.New("oops")
+	if _, err := c.Modify("counts", "visits", func(o *Object) error {
+		o.Body.WriteString("garbage")
+		return oops
+	}, nil); err != oops {
+		t.Errorf("expected %v; got %v", oops, err)
+	}
+	if o, _ := c.Fetch("counts", "visits", nil); o.Body.String() != "11" {
+		t.Errorf("aborted modification was stored: %q", o.Body)
+	}
+
+	// persistent conflicts give up
+	srv.Inject(riaktest.Fault{Method: "PUT", Status: 412})
+	_, err = c.Modify("counts", "visits", increment, &ModifyOptions{MaxAttempts: 3})
+	rerr, ok := err.(*RetryError)
+	if !ok || len(rerr.Attempts) != 3 || rerr.Unwrap() != ErrModified {
+		t.Errorf("expected a RetryError after 3 attempts; got %v", err)
+	}
+	srv.ClearFaults()
+}
+
+func TestModifySiblings(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+	if err := c.SetBucketProps("sets", &BucketProps{Mult: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{`["a"]`, `["b"]`} {
+		c.Store(&Object{Bucket: "sets", Key: "k", Ctype: "application/json", Body: bytes.NewBufferString(v)}, nil)
+	}
+	add := func(o *Object) error {
+		o.Body.Truncate(o.Body.Len() - 1)
+		o.Body.WriteString(`,"c"]`)
+		return nil
+	}
+
+	_, err := c.Modify("sets", "k", add, nil)
+	if mv, ok := err.(*ErrMultipleVclocks); !ok || len(mv.Vclocks) != 2 || mv.Vclocks[0] == "" {
+		t.Fatalf("expected siblings without a resolver; got %v", err)
+	}
+	o, err := c.Modify("sets", "k", add, &ModifyOptions{Resolver: UnionJSONArrays})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Body.String() != `["a","b","c"]` {
+		t.Errorf("bad body %s", o.Body)
+	}
+	// the siblings were resolved
+	if o, err = c.Fetch("sets", "k", nil); err != nil || o.Body.String() != `["a","b","c"]` {
+		t.Errorf("fetched %v %v", o, err)
+	}
+}
+
+func TestModifyNilResolver(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+	if err := c.SetBucketProps("sets", &BucketProps{Mult: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{`["a"]`, `["b"]`} {
+		c.Store(&Object{Bucket: "sets", Key: "k", Ctype: "application/json", Body: bytes.NewBufferString(v)}, nil)
+	}
+	_, err := c.Modify("sets", "k", increment, &ModifyOptions{Resolver: func([]*Object) *Object { return nil }})
+	if mv, ok := err.(*ErrMultipleVclocks); !ok || len(mv.Vclocks) != 2 {
+		t.Errorf("expected the siblings as an error; got %v", err)
+	}
+}
+
+func TestModifyWriteSiblings(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+	calls := 0
+	count := func(o *Object) error {
+		calls++
+		return increment(o)
+	}
+
+	// the write "succeeds" with siblings
+	srv.Inject(riaktest.Fault{Method: "PUT", Status: 300, Times: 1})
+	if _, err := c.Modify("counts", "k", count, nil); err == nil {
+		t.Error("expected siblings without a resolver")
+	} else if _, ok := err.(*ErrMultipleVclocks); !ok {
+		t.Errorf("expected ErrMultipleVclocks; got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("f was applied %d times", calls)
+	}
+
+	calls = 0
+	c.Store(&Object{Bucket: "counts", Key: "k", Body: bytes.NewBufferString("1")}, nil)
+	srv.Inject(riaktest.Fault{Method: "PUT", Status: 300, Times: 1})
+	if _, err := c.Modify("counts", "k", count, &ModifyOptions{Resolver: LastModifiedWins}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("f was applied %d times with a resolver", calls)
+	}
+}
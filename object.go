@@ -31,25 +31,43 @@ func init() {
 type Object struct {
 	Bucket       string            // Object bucket
 	Key          string            // Object key
+	BucketType   string            // Bucket type ("" is the default type)
 	Ctype        string            // Content-Type
 	Vclock       string            // Last seen vector clock
 	eTag         string            // Etag
 	lastModified time.Time         // Last-Modified
-	Links        map[string]Link   // Link: </riak/bucket/key>
+	Links        map[string]Link   // Link: </riak/bucket/key>; the first link for each tag
+	moreLinks    []taggedLink      // every other link, in order
 	Meta         map[string]string // X-Riak-Meta-*
 	Index        map[string]string // X-Riak-Index-*
 	Body         *bytes.Buffer     // Body
 }
 
-// AddLink adds a named key/bucket link to an object
+// LastModified returns the time at which
+// the object was last modified, if known.
+func (o *Object) LastModified() time.Time { return o.lastModified }
+
+// AddLink adds a named key/bucket link to an object,
+// replacing any other links with the same name
 func (o *Object) AddLink(name string, bucket string, key string) {
 	if o.Links == nil {
 		o.Links = make(map[string]Link)
 	}
 	o.Links[name] = Link{Bucket: bucket, Key: key}
+	o.dropMoreLinks(name)
 }
 
-// RemoveLink removes a named link from an object
+// AppendLink adds a named key/bucket link to an object,
+// keeping any other links with the same name
+func (o *Object) AppendLink(name string, bucket string, key string) {
+	if _, ok := o.Links[name]; !ok {
+		o.AddLink(name, bucket, key)
+		return
+	}
+	o.moreLinks = append(o.moreLinks, taggedLink{tag: name, Link: Link{Bucket: bucket, Key: key}})
+}
+
+// RemoveLink removes every link with the given name from an object
 func (o *Object) RemoveLink(name string) {
 	if o.Links == nil {
 		return
@@ -58,6 +76,61 @@ func (o *Object) RemoveLink(name string) {
 	if ok {
 		delete(o.Links, name)
 	}
+	o.dropMoreLinks(name)
+}
+
+// LinksTagged returns every link with the given name,
+// in the order they were added or received
+func (o *Object) LinksTagged(name string) []Link {
+	first, ok := o.Links[name]
+	if !ok {
+		return nil
+	}
+	links := []Link{first}
+	for _, l := range o.moreLinks {
+		if l.tag == name {
+			links = append(links, l.Link)
+		}
+	}
+	return links
+}
+
+func (o *Object) dropMoreLinks(name string) {
+	more := o.moreLinks[:0]
+	for _, l := range o.moreLinks {
+		if l.tag != name {
+			more = append(more, l)
+		}
+	}
+	o.moreLinks = more
+}
+
+// allLinks returns every link on the object. Links
+// whose name has been deleted from Links are skipped.
+func (o *Object) allLinks() []taggedLink {
+	all := make([]taggedLink, 0, len(o.Links)+len(o.moreLinks))
+	for tag, l := range o.Links {
+		all = append(all, taggedLink{tag: tag, Link: l})
+	}
+	for _, l := range o.moreLinks {
+		if _, ok := o.Links[l.tag]; ok {
+			all = append(all, l)
+		}
+	}
+	return all
+}
+
+// setLinks replaces the object's links
+func (o *Object) setLinks(links []taggedLink) {
+	if o.Links != nil {
+		for key := range o.Links {
+			delete(o.Links, key)
+		}
+	}
+	o.moreLinks = o.moreLinks[:0]
+	for _, l := range links {
+		o.AppendLink(l.tag, l.Bucket, l.Key)
+	}
 }
 
 // GetLink gets a named link from an object
@@ -110,7 +183,7 @@ func objectEqual(on *Object, of *Object) bool {
 		return true
 	}
 
-	if on.Bucket != of.Bucket || on.Key != of.Key || on.Ctype != of.Ctype || on.Vclock != of.Vclock || on.eTag != of.eTag {
+	if on.Bucket != of.Bucket || on.Key != of.Key || on.BucketType != of.BucketType || on.Ctype != of.Ctype || on.Vclock != of.Vclock || on.eTag != of.eTag {
 		return false
 	}
 
@@ -160,6 +233,15 @@ func objectEqual(on *Object, of *Object) bool {
 
 	// META
 meta:
+	if len(on.moreLinks) != len(of.moreLinks) {
+		return false
+	}
+	for i := range on.moreLinks {
+		if on.moreLinks[i] != of.moreLinks[i] {
+			return false
+		}
+	}
+
 	if on.Meta == nil {
 		if of.Meta != nil {
 			if len(of.Meta) == 0 {
@@ -261,24 +343,28 @@ body:
 	return true
 }
 
-// /riak/bucket/key
+// /riak/bucket/key, or
+// /types/type/buckets/bucket/keys/key
 func (o *Object) path() string {
+	return objectPath(o.BucketType, o.Bucket, o.Key)
+}
+
+func objectPath(btype string, bucket string, key string) string {
+	if btype != "" {
+		return bucketPath(btype, bucket) + "/keys/" + key
+	}
 	var stack [64]byte
 	buf := bytes.NewBuffer(stack[0:0])
 	buf.WriteString("/riak/")
-	buf.WriteString(o.Bucket)
+	buf.WriteString(bucket)
 	buf.WriteByte('/')
-	buf.WriteString(o.Key)
+	buf.WriteString(key)
 	return buf.String()
 }
 
 func (o *Object) hardReset() {
 	// clear existing values
-	if o.Links != nil {
-		for key := range o.Links {
-			delete(o.Links, key)
-		}
-	}
+	o.setLinks(nil)
 	if o.Meta != nil {
 		for key := range o.Meta {
 			delete(o.Meta, key)
@@ -293,7 +379,7 @@ func (o *Object) hardReset() {
 		o.Body.Reset()
 	}
 	o.lastModified = time.Time{}
-	o.Bucket, o.Key, o.Ctype, o.Vclock, o.eTag = "", "", "", "", ""
+	o.Bucket, o.Key, o.BucketType, o.Ctype, o.Vclock, o.eTag = "", "", "", "", "", ""
 }
 
 // read response headers and body
@@ -301,11 +387,7 @@ func (o *Object) hardReset() {
 // body can be nil
 func (o *Object) fromResponse(hdr map[string][]string, body io.ReadCloser) error {
 	// reset header fields
-	if o.Links != nil {
-		for key := range o.Links {
-			delete(o.Links, key)
-		}
-	}
+	o.setLinks(nil)
 	if o.Meta != nil {
 		for key := range o.Meta {
 			delete(o.Meta, key)
@@ -339,14 +421,11 @@ func (o *Object) fromResponse(hdr map[string][]string, body io.ReadCloser) error
 			o.eTag = vals[0]
 			continue
 		case "Link":
-			if o.Links != nil {
-				for y := range o.Links {
-					delete(o.Links, y)
-				}
-			}
+			var links []taggedLink
 			for _, val := range vals {
-				parseLinks(val, &o.Links)
+				links = append(links, parseLinks(val)...)
 			}
+			o.setLinks(links)
 			continue
 		}
 
@@ -357,7 +436,6 @@ func (o *Object) fromResponse(hdr map[string][]string, body io.ReadCloser) error
 			if o.Meta == nil {
 				o.Meta = make(map[string]string)
 			}
-			o.Meta = make(map[string]string)
 			metakey := strings.SplitAfter(key, "X-Riak-Meta-")[1]
 			o.Meta[metakey] = vals[0]
 			continue
@@ -388,30 +466,29 @@ type Link struct {
 	Key    string
 }
 
-// parse header field to proper link using gross regex stuff
-func parseLinks(str string, links *map[string]Link) {
-	matches := linkrgx.FindAllStringSubmatch(str, -1)
-	if len(matches) == 0 {
-		return
-	}
-	if links == nil || *links == nil {
-		*links = make(map[string]Link)
-	}
-	for _, match := range matches {
+// taggedLink is a link and its name
+type taggedLink struct {
+	tag string
+	Link
+}
+
+// parse header field to proper links using gross regex stuff
+func parseLinks(str string) []taggedLink {
+	var links []taggedLink
+	for _, match := range linkrgx.FindAllStringSubmatch(str, -1) {
 		if len(match) < 5 {
 			// weird
 			continue
 		}
-		(*links)[match[4]] = Link{Bucket: match[2], Key: match[3]}
+		links = append(links, taggedLink{tag: match[4], Link: Link{Bucket: match[2], Key: match[3]}})
 	}
-	return
+	return links
 }
 
 // the opposite direction from parse header
-func formatLinks(links map[string]Link) string {
-	i := 0
+func formatLinks(links []taggedLink) string {
 	buf := bytes.NewBuffer(make([]byte, 64)[0:0])
-	for key, link := range links {
+	for i, link := range links {
 		if i > 0 {
 			buf.WriteString(", ")
 		}
@@ -420,9 +497,8 @@ func formatLinks(links map[string]Link) string {
 		buf.WriteByte('/')
 		buf.WriteString(link.Key)
 		buf.WriteString(">; riaktag=\"")
-		buf.WriteString(key)
+		buf.WriteString(link.tag)
 		buf.WriteString("\"")
-		i++
 	}
 	return buf.String()
 }
@@ -449,7 +525,7 @@ func (o *Object) writeheader(hd http.Header) {
 	}
 
 	if o.Links != nil {
-		hd.Set("Link", formatLinks(o.Links))
+		hd.Set("Link", formatLinks(o.allLinks()))
 	}
 
 	if o.Meta != nil {
@@ -42,6 +42,34 @@ func TestObjectIndexAccessors(t *testing.T) {
 	}
 }
 
+func TestObjectLinkAccessors(t *testing.T) {
+	obj := &Object{}
+	obj.AddLink("post", "posts", "p1")
+	obj.AppendLink("post", "posts", "p2")
+	obj.AppendLink("friend", "people", "bob")
+	hdr := make(http.Header)
+	obj.writeheader(hdr)
+	newob := new(Object)
+	newob.fromResponse(hdr, nil)
+	want := []Link{{Bucket: "posts", Key: "p1"}, {Bucket: "posts", Key: "p2"}}
+	if got := newob.LinksTagged("post"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected links %v; got %v", want, got)
+	}
+	if key, bucket := newob.GetLink("friend"); key != "bob" || bucket != "people" {
+		t.Errorf("friend link is %s/%s", bucket, key)
+	}
+
+	newob.AddLink("post", "posts", "p3")
+	if got := newob.LinksTagged("post"); len(got) != 1 || got[0].Key != "p3" {
+		t.Errorf("AddLink didn't replace the links: %v", got)
+	}
+	newob.AppendLink("post", "posts", "p4")
+	newob.RemoveLink("post")
+	if got := newob.LinksTagged("post"); got != nil {
+		t.Errorf("RemoveLink left %v", got)
+	}
+}
+
 func TestObjectWriteHeader(t *testing.T) {
 	tm := time.Now()
 	obj := &Object{
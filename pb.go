@@ -0,0 +1,351 @@
+package riak
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Riak protocol buffers message codes
+const (
+	rpbErrorResp        = 0
+	rpbPingReq          = 1
+	rpbPingResp         = 2
+	rpbGetReq           = 9
+	rpbGetResp          = 10
+	rpbPutReq           = 11
+	rpbPutResp          = 12
+	rpbDelReq           = 13
+	rpbDelResp          = 14
+	rpbListBucketsReq   = 15
+	rpbListBucketsResp  = 16
+	rpbListKeysReq      = 17
+	rpbListKeysResp     = 18
+	rpbGetBucketReq     = 19
+	rpbGetBucketResp    = 20
+	rpbSetBucketReq     = 21
+	rpbSetBucketResp    = 22
+	rpbMapRedReq        = 23
+	rpbMapRedResp       = 24
+	rpbIndexReq         = 25
+	rpbIndexResp        = 26
+	rpbResetBucketReq   = 29
+	rpbResetBucketResp  = 30
+	rpbGetBucketTypeReq = 31
+	rpbSetBucketTypeReq = 32
+
+	rpbCounterUpdateReq  = 50
+	rpbCounterUpdateResp = 51
+	rpbCounterGetReq     = 52
+	rpbCounterGetResp    = 53
+)
+
+// largest message we're willing to read
+const pbMaxMsg = 64 << 20
+
+// pbuf is a protocol buffers message encoder.
+// Only the wire types that riak uses are supported.
+type pbuf []byte
+
+func (b *pbuf) uvarint(v uint64) {
+	var stack [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(stack[:], v)
+	*b = append(*b, stack[:n]...)
+}
+
+func (b *pbuf) tag(field int, wire int) { b.uvarint(uint64(field<<3 | wire)) }
+
+// varint writes an integer field
+func (b *pbuf) varint(field int, v uint64) {
+	b.tag(field, 0)
+	b.uvarint(v)
+}
+
+// sint writes a zigzag-encoded (sint64) integer field
+func (b *pbuf) sint(field int, v int64) {
+	b.varint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+// unzigzag decodes the value of a sint64 field
+func unzigzag(v uint64) int64 { return int64(v>>1) ^ -int64(v&1) }
+
+// bool writes a boolean field
+func (b *pbuf) bool(field int, v bool) {
+	if v {
+		b.varint(field, 1)
+	} else {
+		b.varint(field, 0)
+	}
+}
+
+// bytes writes a length-delimited field
+func (b *pbuf) bytes(field int, p []byte) {
+	b.tag(field, 2)
+	b.uvarint(uint64(len(p)))
+	*b = append(*b, p...)
+}
+
+// str writes a string field
+func (b *pbuf) str(field int, s string) {
+	b.tag(field, 2)
+	b.uvarint(uint64(len(s)))
+	*b = append(*b, s...)
+}
+
+var errPBFormat = errors.New("riak: malformed protocol buffers message")
+
+// pbfields calls 'fn' for every field in 'p'. For varint
+// fields 'v' is set, and for length-delimited fields 'data' is set.
+func pbfields(p []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(p) > 0 {
+		tag, n := binary.Uvarint(p)
+		if n <= 0 {
+			return errPBFormat
+		}
+		p = p[n:]
+		field := int(tag >> 3)
+		var v uint64
+		var data []byte
+		switch tag & 7 {
+		case 0:
+			v, n = binary.Uvarint(p)
+			if n <= 0 {
+				return errPBFormat
+			}
+			p = p[n:]
+		case 1:
+			if len(p) < 8 {
+				return errPBFormat
+			}
+			v = binary.LittleEndian.Uint64(p)
+			p = p[8:]
+		case 2:
+			l, n := binary.Uvarint(p)
+			if n <= 0 || uint64(len(p)-n) < l {
+				return errPBFormat
+			}
+			data = p[n : n+int(l)]
+			p = p[n+int(l):]
+		case 5:
+			if len(p) < 4 {
+				return errPBFormat
+			}
+			v = uint64(binary.LittleEndian.Uint32(p))
+			p = p[4:]
+		default:
+			return errPBFormat
+		}
+		if err := fn(field, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrPB is an error message returned by riak
+// over the protocol buffers interface
+type ErrPB struct {
+	Msg  string
+	Code uint32
+}
+
+func (e *ErrPB) Error() string { return "riak: " + e.Msg }
+
+func decodeErrPB(p []byte) error {
+	e := new(ErrPB)
+	err := pbfields(p, func(f int, v uint64, data []byte) error {
+		switch f {
+		case 1:
+			e.Msg = string(data)
+		case 2:
+			e.Code = uint32(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e
+}
+
+// writeFrame writes one length-prefixed message
+func writeFrame(w io.Writer, code byte, msg []byte) error {
+	buf := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)+1))
+	buf[4] = code
+	buf = append(buf, msg...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame reads one length-prefixed message
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	l := binary.BigEndian.Uint32(hdr[:4])
+	if l < 1 || l > pbMaxMsg {
+		return 0, nil, errPBFormat
+	}
+	msg := make([]byte, l-1)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], msg, nil
+}
+
+type pbConn struct {
+	mu       sync.Mutex // guards conn and deadline
+	conn     net.Conn
+	rd       *bufio.Reader
+	deadline time.Time
+	// redial is set on connections taken from the idle
+	// pool, which riak may have closed in the meantime
+	redial func() (net.Conn, error)
+}
+
+func (c *pbConn) setDeadline(t time.Time) {
+	c.mu.Lock()
+	c.deadline = t
+	c.conn.SetDeadline(t)
+	c.mu.Unlock()
+}
+
+// roundTrip writes a request and reads the first response,
+// turning RpbErrorResp into an error. If the first exchange
+// on a reused connection fails because the peer hung up,
+// the connection is redialed and the request is sent again.
+func (c *pbConn) roundTrip(code byte, msg []byte, want byte) ([]byte, error) {
+	res, err := c.exchange(code, msg, want)
+	if err != nil && c.redial != nil && hungUp(err) {
+		conn, derr := c.redial()
+		if derr != nil {
+			c.redial = nil
+			return nil, derr
+		}
+		c.mu.Lock()
+		c.conn.Close()
+		c.conn = conn
+		conn.SetDeadline(c.deadline)
+		c.mu.Unlock()
+		c.rd.Reset(conn)
+		res, err = c.exchange(code, msg, want)
+	}
+	c.redial = nil
+	return res, err
+}
+
+func (c *pbConn) exchange(code byte, msg []byte, want byte) ([]byte, error) {
+	if err := writeFrame(c.conn, code, msg); err != nil {
+		return nil, err
+	}
+	return c.next(want)
+}
+
+// hungUp reports whether err means that the peer
+// closed the connection before replying
+func hungUp(err error) bool {
+	return err == io.EOF || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// next reads the next response
+func (c *pbConn) next(want byte) ([]byte, error) {
+	code, msg, err := readFrame(c.rd)
+	if err != nil {
+		return nil, err
+	}
+	switch code {
+	case want:
+		return msg, nil
+	case rpbErrorResp:
+		return nil, decodeErrPB(msg)
+	default:
+		return nil, errPBFormat
+	}
+}
+
+// pbPool is a pool of connections to one node
+type pbPool struct {
+	addr    string
+	timeout time.Duration // dial timeout
+	idle    chan *pbConn
+
+	lock   sync.Mutex
+	closed bool // connections aren't pooled after Close
+}
+
+func newPBPool(addr string, size int) *pbPool {
+	if size <= 0 {
+		size = 8
+	}
+	return &pbPool{
+		addr:    addr,
+		timeout: 5 * time.Second,
+		idle:    make(chan *pbConn, size),
+	}
+}
+
+func (p *pbPool) get(ctx context.Context) (*pbConn, error) {
+	select {
+	case c := <-p.idle:
+		c.redial = func() (net.Conn, error) { return p.dial(ctx) }
+		return c, nil
+	default:
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pbConn{conn: conn, rd: bufio.NewReader(conn)}, nil
+}
+
+func (p *pbPool) dial(ctx context.Context) (net.Conn, error) {
+	d := net.Dialer{Timeout: p.timeout}
+	return d.DialContext(ctx, "tcp", p.addr)
+}
+
+// put returns a connection to the pool. Connections
+// that have seen an error are closed, since the
+// stream may be in an unknown state.
+func (p *pbPool) put(c *pbConn, err error) {
+	if err != nil {
+		if _, ok := err.(*ErrPB); !ok {
+			c.conn.Close()
+			return
+		}
+	}
+	c.redial = nil
+	c.setDeadline(time.Time{})
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		c.conn.Close()
+		return
+	}
+	select {
+	case p.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (p *pbPool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closed = true
+	for {
+		select {
+		case c := <-p.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package riak
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePB is a minimal in-memory riak
+// protocol buffers server
+type fakePB struct {
+	t     *testing.T
+	ln    net.Listener
+	lock  sync.Mutex
+	objs  map[string]map[string]*fakeObj
+	ctrs  map[string]int64 // counters by bucket/key
+	props map[string]map[string]interface{}
+	codes []byte     // every message code received
+	conns []net.Conn // accepted connections, for hangup
+	next  int        // for generating keys and vclocks
+}
+
+type fakeObj struct {
+	content []byte // encoded RpbContent
+	vclock  []byte
+	index   []pbPair
+}
+
+func newFakePB(t *testing.T) *fakePB {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakePB{t: t, ln: ln, objs: make(map[string]map[string]*fakeObj), ctrs: make(map[string]int64),
+		props: make(map[string]map[string]interface{})}
+	go f.serve()
+	return f
+}
+
+func (f *fakePB) addr() string { return f.ln.Addr().String() }
+
+func (f *fakePB) Close() { f.ln.Close() }
+
+func (f *fakePB) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.conns = append(f.conns, conn)
+		f.lock.Unlock()
+		go f.handle(conn)
+	}
+}
+
+// hangup closes every open connection, like
+// a node that restarts or times out idle clients
+func (f *fakePB) hangup() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakePB) handle(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		code, msg, err := readFrame(rd)
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.codes = append(f.codes, code)
+		rcode, replies := f.reply(code, msg)
+		f.lock.Unlock()
+		for _, r := range replies {
+			if err := writeFrame(conn, rcode, r); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func pberr(msg string) (byte, [][]byte) {
+	var b pbuf
+	b.str(1, msg)
+	b.varint(2, 0)
+	return rpbErrorResp, [][]byte{b}
+}
+
+// fields decodes the top-level fields of a message
+func fields(msg []byte) map[int][][]byte {
+	out := make(map[int][][]byte)
+	pbfields(msg, func(f int, v uint64, data []byte) error {
+		if data == nil {
+			data = []byte(strconv.FormatUint(v, 10))
+		}
+		out[f] = append(out[f], data)
+		return nil
+	})
+	return out
+}
+
+func first(m map[int][][]byte, f int) string {
+	if len(m[f]) == 0 {
+		return ""
+	}
+	return string(m[f][0])
+}
+
+func (f *fakePB) reply(code byte, msg []byte) (byte, [][]byte) {
+	req := fields(msg)
+	switch code {
+	case rpbPingReq:
+		return rpbPingResp, [][]byte{nil}
+
+	case rpbGetReq:
+		o := f.objs[first(req, 1)][first(req, 2)]
+		var b pbuf
+		if o != nil {
+			b.bytes(1, o.content)
+			b.bytes(2, o.vclock)
+		}
+		return rpbGetResp, [][]byte{b}
+
+	case rpbPutReq:
+		bucket, key := first(req, 1), first(req, 2)
+		if f.objs[bucket] == nil {
+			f.objs[bucket] = make(map[string]*fakeObj)
+		}
+		old := f.objs[bucket][key]
+		if first(req, 9) == "1" && (old == nil || !bytes.Equal(old.vclock, req[3][0])) {
+			return pberr("modified")
+		}
+		f.next++
+		created := key == ""
+		if created {
+			key = "generated" + strconv.Itoa(f.next)
+		}
+		content, _ := decodeContent(req[4][0])
+		// fields can be appended to an encoded message
+		stored := pbuf(append([]byte(nil), req[4][0]...))
+		stored.str(5, "vtag"+strconv.Itoa(f.next))
+		o := &fakeObj{
+			content: stored,
+			vclock:  []byte("vclock" + strconv.Itoa(f.next)),
+			index:   content.index,
+		}
+		f.objs[bucket][key] = o
+		var b pbuf
+		if first(req, 7) == "1" {
+			b.bytes(1, o.content)
+		}
+		b.bytes(2, o.vclock)
+		if created {
+			b.str(3, key)
+		}
+		return rpbPutResp, [][]byte{b}
+
+	case rpbDelReq:
+		delete(f.objs[first(req, 1)], first(req, 2))
+		return rpbDelResp, [][]byte{nil}
+
+	case rpbListBucketsReq:
+		var b pbuf
+		for name := range f.objs {
+			b.str(1, name)
+		}
+		return rpbListBucketsResp, [][]byte{b}
+
+	case rpbListKeysReq:
+		// stream one key per message
+		var out [][]byte
+		for key := range f.objs[first(req, 1)] {
+			var b pbuf
+			b.str(1, key)
+			out = append(out, b)
+		}
+		var done pbuf
+		done.bool(2, true)
+		return rpbListKeysResp, append(out, done)
+
+	case rpbIndexReq:
+		if first(req, 3) != "0" {
+			return pberr("only eq queries are supported")
+		}
+		var b pbuf
+		for key, o := range f.objs[first(req, 1)] {
+			for _, p := range o.index {
+				if p.key == first(req, 2) && p.value == first(req, 4) {
+					b.str(1, key)
+				}
+			}
+		}
+		return rpbIndexResp, [][]byte{b}
+
+	case rpbGetBucketReq:
+		var b pbuf
+		b.bytes(1, encodeProps(f.props[first(req, 1)]))
+		return rpbGetBucketResp, [][]byte{b}
+
+	case rpbSetBucketReq:
+		props := make(map[string]interface{})
+		if err := decodeProps([]byte(first(req, 2)), props); err != nil {
+			return pberr(err.Error())
+		}
+		// encodeProps wants what encoding/json produces
+		buf, _ := json.Marshal(props)
+		props = nil
+		json.Unmarshal(buf, &props)
+		bucket := first(req, 1)
+		if f.props[bucket] == nil {
+			f.props[bucket] = make(map[string]interface{})
+		}
+		for k, v := range props {
+			f.props[bucket][k] = v
+		}
+		return rpbSetBucketResp, [][]byte{nil}
+
+	case rpbCounterUpdateReq, rpbCounterGetReq:
+		if first(req, 1) == "nomult" {
+			return pberr("Counters require bucket property 'allow_mult=true'")
+		}
+		name := first(req, 1) + "/" + first(req, 2)
+		val, ok := f.ctrs[name]
+		var b pbuf
+		if code == rpbCounterUpdateReq {
+			delta, _ := strconv.ParseUint(first(req, 3), 10, 64)
+			val += unzigzag(delta)
+			f.ctrs[name] = val
+			if first(req, 7) == "1" {
+				b.sint(1, val)
+			}
+			return rpbCounterUpdateResp, [][]byte{b}
+		}
+		if ok {
+			b.sint(1, val)
+		}
+		return rpbCounterGetResp, [][]byte{b}
+	}
+	f.t.Errorf("unexpected message code %d", code)
+	return pberr("unknown message code")
+}
+
+func TestPBFrame(t *testing.T) {
+	var b pbuf
+	b.str(1, "bucket")
+	b.varint(3, 0xfffffffd)
+	b.bool(5, true)
+
+	buf := bytes.NewBuffer(nil)
+	if err := writeFrame(buf, rpbGetReq, b); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	if l := binary.BigEndian.Uint32(raw); int(l) != len(b)+1 {
+		t.Errorf("expected length prefix %d; got %d", len(b)+1, l)
+	}
+	if raw[4] != rpbGetReq {
+		t.Errorf("expected message code %d; got %d", rpbGetReq, raw[4])
+	}
+
+	code, msg, err := readFrame(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != rpbGetReq {
+		t.Errorf("expected message code %d; got %d", rpbGetReq, code)
+	}
+	m := fields(msg)
+	if first(m, 1) != "bucket" || first(m, 3) != "4294967293" || first(m, 5) != "1" {
+		t.Errorf("fields decoded incorrectly: %v", m)
+	}
+
+	// zero-length frames are illegal
+	_, _, err = readFrame(bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+	if err != errPBFormat {
+		t.Errorf("expected errPBFormat; got %v", err)
+	}
+}
+
+func TestPBTransport(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 2))
+	defer c.Close()
+
+	obj := &Object{
+		Bucket: "testing",
+		Key:    "pb",
+		Ctype:  "text/plain",
+		Body:   bytes.NewBufferString("Testing, 1, 2, 3"),
+	}
+	obj.AddIndex("username_bin", "bob123")
+	obj.AddLink("child", "testing", "pbchild")
+	obj.Meta = map[string]string{"Agent": "testing"}
+	if err := c.Store(obj, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Fetch("testing", "pb", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Body.String() != "Testing, 1, 2, 3" {
+		t.Errorf("expected body %q; got %q", "Testing, 1, 2, 3", got.Body.String())
+	}
+	if got.Vclock != obj.Vclock || got.Vclock == "" {
+		t.Errorf("expected vclock %q; got %q", obj.Vclock, got.Vclock)
+	}
+	if got.GetIndex("username_bin") != "bob123" {
+		t.Errorf("index not round-tripped: %v", got.Index)
+	}
+	if got.Links["child"] != (Link{Bucket: "testing", Key: "pbchild"}) {
+		t.Errorf("link not round-tripped: %v", got.Links)
+	}
+	if got.Meta["Agent"] != "testing" {
+		t.Errorf("meta not round-tripped: %v", got.Meta)
+	}
+
+	// a stale vclock should fail to merge
+	stale := *got
+	stale.Body = bytes.NewBufferString("stale")
+	got.Body.WriteString(" more data")
+	if err := c.Merge(got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Merge(&stale, nil); err != ErrModified {
+		t.Fatalf("expected ErrModified; got %v", err)
+	}
+
+	created := &Object{Bucket: "testing", Body: bytes.NewBufferString("new")}
+	if err := c.CreateObject(created, nil); err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == "" {
+		t.Error("expected CreateObject to assign a key")
+	}
+
+	keys, err := c.ListBucketKeys("testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != created.Key || keys[1] != "pb" {
+		t.Errorf("unexpected keys %q", keys)
+	}
+
+	buckets, err := c.GetBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0] != "testing" {
+		t.Errorf("unexpected buckets %q", buckets)
+	}
+
+	kr, err := c.IndexLookup("testing", "username_bin", "bob123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kr.Keys) != 1 || kr.Keys[0] != "pb" {
+		t.Errorf("unexpected index lookup result %q", kr.Keys)
+	}
+
+	if err := c.Delete(got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch("testing", "pb", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	want := []byte{
+		rpbPutReq, rpbGetReq, rpbPutReq, rpbPutReq, rpbPutReq,
+		rpbListKeysReq, rpbListBucketsReq, rpbIndexReq, rpbDelReq, rpbGetReq,
+	}
+	if !bytes.Equal(srv.codes, want) {
+		t.Errorf("expected message codes %v; got %v", want, srv.codes)
+	}
+}
+
+func TestPBErrStatus(t *testing.T) {
+	cases := map[string]int{
+		"modified":                       412,
+		"match_found":                    412,
+		"overload":                       503,
+		"{insufficient_vnodes,1,need,2}": 503,
+		"{n_val_violation,3}":            400,
+		"Counters require bucket property 'allow_mult=true'": 409,
+		// free text isn't guessed at
+		"bad things happened":              500,
+		"invalid_thing":                    500,
+		"the object wasn't modified":       500,
+		"{some_other_error,\"bad input\"}": 500,
+	}
+	for msg, want := range cases {
+		res := pbErrResponse(nil, &ErrPB{Msg: msg})
+		if res.StatusCode != want {
+			t.Errorf("%q: expected status %d; got %d", msg, want, res.StatusCode)
+		}
+	}
+}
+
+func TestPBFetchVtag(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 1))
+	defer c.Close()
+	if err := c.Store(&Object{Bucket: "testing", Key: "k", Body: bytes.NewBufferString("v")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch("testing", "k", map[string]string{"vtag": "vtag1"}); err != nil {
+		t.Errorf("fetching a matching vtag: %v", err)
+	}
+	if _, err := c.Fetch("testing", "k", map[string]string{"vtag": "nope"}); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a vtag that matches no sibling; got %v", err)
+	}
+}
+
+func TestPBStaleConn(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 1))
+	if err := c.Store(&Object{Bucket: "testing", Key: "k", Body: bytes.NewBufferString("v")}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pooled connection is redialed
+	srv.hangup()
+	if o, err := c.Fetch("testing", "k", nil); err != nil || o.Body.String() != "v" {
+		t.Fatalf("fetch on a stale connection: %v %v", o, err)
+	}
+	srv.hangup()
+	if keys, err := c.ListBucketKeys("testing"); err != nil || len(keys) != 1 {
+		t.Fatalf("listing keys on a stale connection: %v %v", keys, err)
+	}
+
+	// ... but only once
+	pool := c.base.(*pbTransport).pool
+	srv.hangup()
+	srv.Close()
+	if _, err := c.Fetch("testing", "k", nil); err == nil {
+		t.Error("expected an error")
+	}
+
+	// connections aren't pooled after Close
+	srv = newFakePB(t)
+	defer srv.Close()
+	pool.addr = srv.addr()
+	c.Close()
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pool.idle); n != 0 {
+		t.Errorf("%d connections were pooled after Close", n)
+	}
+}
+
+func TestPBMaxValue(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 1), PBMaxValue(10))
+	defer c.Close()
+
+	o := &Object{Bucket: "testing", Key: "big"}
+	big := strings.Repeat("x", 20)
+	if err := c.StoreReader(o, strings.NewReader(big), 20, nil); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge; got %v", err)
+	}
+	// the length isn't always known up front
+	if err := c.StoreReader(o, io.MultiReader(strings.NewReader(big)), -1, nil); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge; got %v", err)
+	}
+	if _, err := c.Fetch("testing", "big", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+	if err := c.StoreReader(o, strings.NewReader("small"), 5, nil); err != nil {
+		t.Fatal(err)
+	}
+	if o, err := c.Fetch("testing", "big", nil); err != nil || o.Body.String() != "small" {
+		t.Errorf("fetched %v %v", o, err)
+	}
+}
@@ -0,0 +1,1294 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protobuf returns an Option that makes the client talk to the
+// node at 'addr' (e.g. "localhost:8087") over riak's protocol
+// buffers interface instead of HTTP. Up to 'poolSize' idle
+// connections are kept open between requests; the number of
+// connections in use at once isn't limited. The host passed
+// to NewClient is ignored, and every Client method works the
+// same way over either transport, with the exception of link
+// walking, search, data types, counters in bucket types, Stats
+// and Resources, which are only supported over HTTP. Values are
+// never streamed: StoreReader and FetchReader hold the whole
+// value in memory (see PBMaxValue).
+func Protobuf(addr string, poolSize int) Option {
+	return func(c *Client) {
+		t := &pbTransport{pool: newPBPool(addr, poolSize), maxValue: pbMaxMsg}
+		c.cl, c.base = t, t
+		c.host = ""
+	}
+}
+
+// pbTransport is a Transport that translates the
+// HTTP requests that the client builds into
+// protocol buffers messages, and the replies
+// back into HTTP responses.
+type pbTransport struct {
+	pool     *pbPool
+	maxValue int64 // see PBMaxValue
+}
+
+// ErrTooLarge is returned when a value is larger
+// than the limit set with PBMaxValue
+var ErrTooLarge = errors.New("riak: value is too large to send over protocol buffers")
+
+// PBMaxValue limits the size of the values that a client
+// configured with Protobuf sends. The protocol buffers
+// interface can't stream values, so each one is read into
+// memory before it's sent, even by StoreReader; values that
+// are larger than 'n' bytes are refused with ErrTooLarge
+// instead. The default is 64MB. Like WrapTransport, it has
+// to come after Protobuf in the option list.
+func PBMaxValue(n int64) Option {
+	return func(c *Client) {
+		if t, ok := c.base.(*pbTransport); ok {
+			t.maxValue = n
+		}
+	}
+}
+
+// readBody reads a request body of at most t.maxValue bytes
+func (t *pbTransport) readBody(req *http.Request) ([]byte, error) {
+	defer req.Body.Close()
+	if req.ContentLength > t.maxValue {
+		return nil, ErrTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, t.maxValue+1))
+	if err == nil && int64(len(body)) > t.maxValue {
+		return nil, ErrTooLarge
+	}
+	return body, err
+}
+
+func (t *pbTransport) Close() error { return t.pool.Close() }
+
+var errPBUnsupported = errors.New("riak: request not supported over protocol buffers")
+
+// Do performs the request over a pooled connection
+func (t *pbTransport) Do(req *http.Request) (*http.Response, error) {
+	rt, ok := parseRoute(req.URL.Path)
+	if !ok {
+		return nil, errPBUnsupported
+	}
+	ctx := req.Context()
+	conn, err := t.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		conn.setDeadline(dl)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// unblocks any pending reads or writes
+		conn.setDeadline(time.Unix(1, 0))
+	})
+	res, err := t.dispatch(conn, req, rt)
+	if !stop() {
+		// the connection's deadline was clobbered
+		conn.conn.Close()
+	} else {
+		t.pool.put(conn, err)
+	}
+	if perr, ok := err.(*ErrPB); ok {
+		return pbErrResponse(req, perr), nil
+	}
+	return res, err
+}
+
+const (
+	routePing = iota
+	routeObject
+	routeKeys
+	routeBuckets
+	routeProps
+	routeIndex
+	routeMapred
+	routeCounter
+	routeTypeProps
+)
+
+// route is the parsed form of a request path
+type route struct {
+	kind   int
+	btype  string // bucket type
+	bucket string
+	key    string
+	index  string
+	args   []string // index value, or range start and end
+}
+
+// parseRoute understands the paths that
+// the client builds (both /riak/... and /buckets/...)
+func parseRoute(path string) (route, bool) {
+	var rt route
+	seg := strings.Split(strings.Trim(path, "/"), "/")
+	if len(seg) >= 2 && seg[0] == "types" {
+		rt.btype = seg[1]
+		seg = seg[2:]
+	}
+	switch {
+	case len(seg) == 1 && seg[0] == "ping":
+		rt.kind = routePing
+	case len(seg) == 1 && seg[0] == "mapred":
+		rt.kind = routeMapred
+	case len(seg) == 2 && seg[0] == "riak":
+		rt.kind, rt.bucket = routeObject, seg[1]
+	case len(seg) == 3 && seg[0] == "riak":
+		rt.kind, rt.bucket, rt.key = routeObject, seg[1], seg[2]
+	case len(seg) == 1 && seg[0] == "props" && rt.btype != "":
+		rt.kind = routeTypeProps
+	case len(seg) == 1 && seg[0] == "buckets":
+		rt.kind = routeBuckets
+	case len(seg) == 3 && seg[0] == "buckets" && seg[2] == "keys":
+		rt.kind, rt.bucket = routeKeys, seg[1]
+	case len(seg) == 4 && seg[0] == "buckets" && seg[2] == "keys":
+		rt.kind, rt.bucket, rt.key = routeObject, seg[1], seg[3]
+	case len(seg) == 4 && seg[0] == "buckets" && seg[2] == "counters":
+		rt.kind, rt.bucket, rt.key = routeCounter, seg[1], seg[3]
+	case len(seg) == 3 && seg[0] == "buckets" && seg[2] == "props":
+		rt.kind, rt.bucket = routeProps, seg[1]
+	case (len(seg) == 5 || len(seg) == 6) && seg[0] == "buckets" && seg[2] == "index":
+		rt.kind, rt.bucket, rt.index, rt.args = routeIndex, seg[1], seg[3], seg[4:]
+	default:
+		return rt, false
+	}
+	return rt, true
+}
+
+func (t *pbTransport) dispatch(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	switch rt.kind {
+	case routePing:
+		if _, err := conn.roundTrip(rpbPingReq, nil, rpbPingResp); err != nil {
+			return nil, err
+		}
+		return pbresponse(req, 200, nil, []byte("OK")), nil
+	case routeObject:
+		switch req.Method {
+		case "GET", "HEAD":
+			return t.get(conn, req, rt)
+		case "PUT", "POST":
+			return t.put(conn, req, rt)
+		case "DELETE":
+			return t.del(conn, req, rt)
+		}
+	case routeKeys:
+		if req.Method == "POST" {
+			return t.put(conn, req, rt)
+		}
+		return t.listKeys(conn, req, rt)
+	case routeBuckets:
+		return t.listBuckets(conn, req, rt)
+	case routeProps, routeTypeProps:
+		return t.props(conn, req, rt)
+	case routeIndex:
+		return t.index(conn, req, rt)
+	case routeMapred:
+		return t.mapred(conn, req)
+	case routeCounter:
+		// the counter messages have no bucket type
+		if rt.btype != "" {
+			return nil, errPBUnsupported
+		}
+		if req.Method == "POST" {
+			return t.incrCounter(conn, req, rt)
+		}
+		return t.getCounter(conn, req, rt)
+	}
+	return nil, errPBUnsupported
+}
+
+// pbresponse synthesizes an HTTP response
+func pbresponse(req *http.Request, status int, hdr http.Header, body []byte) *http.Response {
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        hdr,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// riak's error messages for errors that the HTTP
+// interface reports with a status code other than 500
+var pbErrStatus = map[string]int{
+	"modified":       412, // if_not_modified
+	"match_found":    412, // if_none_match
+	"notfound":       404,
+	"timeout":        503,
+	"overload":       503,
+	"too_many_fails": 503,
+
+	"Counters require bucket property 'allow_mult=true'": 409,
+}
+
+// the same, for errors that riak formats as
+// erlang tuples, e.g. "{n_val_violation,3}"
+var pbErrTupleStatus = map[string]int{
+	"insufficient_vnodes": 503,
+	"r_val_unsatisfied":   503,
+	"w_val_unsatisfied":   503,
+	"pr_val_unsatisfied":  503,
+	"pw_val_unsatisfied":  503,
+	"n_val_violation":     400,
+	"precommit_fail":      403,
+}
+
+// map riak's error messages onto the
+// status codes that the HTTP interface uses
+func pbErrResponse(req *http.Request, e *ErrPB) *http.Response {
+	msg := strings.TrimSpace(e.Msg)
+	status, ok := pbErrStatus[msg]
+	if !ok && strings.HasPrefix(msg, "{") {
+		atom := msg[1:]
+		if i := strings.IndexAny(atom, ",}"); i >= 0 {
+			atom = atom[:i]
+		}
+		status, ok = pbErrTupleStatus[atom]
+	}
+	if !ok {
+		status = 500
+	}
+	hdr := make(http.Header)
+	hdr.Set("Content-Type", "text/plain")
+	return pbresponse(req, status, hdr, []byte(e.Msg))
+}
+
+// quorum writes a quorum option, which may
+// be a number or one of the symbolic names
+func quorum(b *pbuf, field int, v string) {
+	switch v {
+	case "":
+		return
+	case "one":
+		b.varint(field, 0xfffffffe)
+	case "quorum":
+		b.varint(field, 0xfffffffd)
+	case "all":
+		b.varint(field, 0xfffffffc)
+	case "default":
+		b.varint(field, 0xfffffffb)
+	default:
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err == nil {
+			b.varint(field, n)
+		}
+	}
+}
+
+func quorumName(v uint64) interface{} {
+	switch v {
+	case 0xfffffffe:
+		return "one"
+	case 0xfffffffd:
+		return "quorum"
+	case 0xfffffffc:
+		return "all"
+	case 0xfffffffb:
+		return "default"
+	}
+	return v
+}
+
+func boolopt(b *pbuf, field int, v string) {
+	if v == "" {
+		return
+	}
+	b.bool(field, v == "true")
+}
+
+func decodeVclock(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	p, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return []byte(s)
+	}
+	return p
+}
+
+type pbPair struct {
+	key, value string
+}
+
+func decodePair(p []byte) (pbPair, error) {
+	var pr pbPair
+	err := pbfields(p, func(f int, v uint64, data []byte) error {
+		switch f {
+		case 1:
+			pr.key = string(data)
+		case 2:
+			pr.value = string(data)
+		}
+		return nil
+	})
+	return pr, err
+}
+
+func (p pbPair) encode() []byte {
+	var b pbuf
+	b.str(1, p.key)
+	b.str(2, p.value)
+	return b
+}
+
+// pbContent is an RpbContent
+type pbContent struct {
+	value    []byte
+	ctype    string
+	charset  string
+	encoding string
+	vtag     string
+	links    []pbLink
+	lastMod  uint32
+	lastUsec uint32
+	meta     []pbPair
+	index    []pbPair
+	deleted  bool
+}
+
+type pbLink struct {
+	bucket, key, tag string
+}
+
+func decodeContent(p []byte) (*pbContent, error) {
+	c := new(pbContent)
+	err := pbfields(p, func(f int, v uint64, data []byte) error {
+		switch f {
+		case 1:
+			c.value = data
+		case 2:
+			c.ctype = string(data)
+		case 3:
+			c.charset = string(data)
+		case 4:
+			c.encoding = string(data)
+		case 5:
+			c.vtag = string(data)
+		case 6:
+			var l pbLink
+			err := pbfields(data, func(f int, v uint64, data []byte) error {
+				switch f {
+				case 1:
+					l.bucket = string(data)
+				case 2:
+					l.key = string(data)
+				case 3:
+					l.tag = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			c.links = append(c.links, l)
+		case 7:
+			c.lastMod = uint32(v)
+		case 8:
+			c.lastUsec = uint32(v)
+		case 9, 10:
+			pr, err := decodePair(data)
+			if err != nil {
+				return err
+			}
+			if f == 9 {
+				c.meta = append(c.meta, pr)
+			} else {
+				c.index = append(c.index, pr)
+			}
+		case 11:
+			c.deleted = v != 0
+		}
+		return nil
+	})
+	return c, err
+}
+
+func (c *pbContent) encode() []byte {
+	var b pbuf
+	b.bytes(1, c.value)
+	if c.ctype != "" {
+		b.str(2, c.ctype)
+	}
+	if c.charset != "" {
+		b.str(3, c.charset)
+	}
+	if c.encoding != "" {
+		b.str(4, c.encoding)
+	}
+	for _, l := range c.links {
+		var lb pbuf
+		lb.str(1, l.bucket)
+		lb.str(2, l.key)
+		lb.str(3, l.tag)
+		b.bytes(6, lb)
+	}
+	for _, p := range c.meta {
+		b.bytes(9, p.encode())
+	}
+	for _, p := range c.index {
+		b.bytes(10, p.encode())
+	}
+	return b
+}
+
+// header writes the HTTP headers that riak
+// would have sent for this content
+func (c *pbContent) header(hdr http.Header) {
+	ctype := c.ctype
+	if c.charset != "" {
+		ctype += "; charset=" + c.charset
+	}
+	if ctype != "" {
+		hdr.Set("Content-Type", ctype)
+	}
+	if c.encoding != "" {
+		hdr.Set("Content-Encoding", c.encoding)
+	}
+	if c.vtag != "" {
+		hdr.Set("Etag", c.vtag)
+	}
+	if c.lastMod != 0 {
+		hdr.Set("Last-Modified", time.Unix(int64(c.lastMod), 0).UTC().Format(time.RFC1123))
+	}
+	if len(c.links) > 0 {
+		links := make([]string, len(c.links))
+		for i, l := range c.links {
+			links[i] = "</riak/" + l.bucket + "/" + l.key + ">; riaktag=\"" + l.tag + "\""
+		}
+		hdr.Set("Link", strings.Join(links, ", "))
+	}
+	for _, p := range c.meta {
+		hdr.Set("X-Riak-Meta-"+p.key, p.value)
+	}
+	for _, p := range c.index {
+		key := "X-Riak-Index-" + p.key
+		if old := hdr.Get(key); old != "" {
+			hdr.Set(key, old+", "+p.value)
+		} else {
+			hdr.Set(key, p.value)
+		}
+	}
+}
+
+// contentFromRequest builds content out of
+// an object's request headers and body
+func (t *pbTransport) contentFromRequest(req *http.Request) (*pbContent, error) {
+	c := new(pbContent)
+	if req.Body != nil {
+		body, err := t.readBody(req)
+		if err != nil {
+			return nil, err
+		}
+		c.value = body
+	}
+	c.ctype = req.Header.Get("Content-Type")
+	if i := strings.Index(c.ctype, "; charset="); i >= 0 {
+		c.charset = c.ctype[i+len("; charset="):]
+		c.ctype = c.ctype[:i]
+	}
+	c.encoding = req.Header.Get("Content-Encoding")
+	for k, vals := range req.Header {
+		if len(vals) == 0 {
+			continue
+		}
+		key := textproto.CanonicalMIMEHeaderKey(k)
+		switch {
+		case key == "Link":
+			for _, v := range vals {
+				for _, l := range parseLinks(v) {
+					c.links = append(c.links, pbLink{bucket: l.Bucket, key: l.Key, tag: l.tag})
+				}
+			}
+		case strings.HasPrefix(key, "X-Riak-Meta-"):
+			c.meta = append(c.meta, pbPair{key: strings.TrimPrefix(key, "X-Riak-Meta-"), value: vals[0]})
+		case strings.HasPrefix(key, "X-Riak-Index-"):
+			ikey := strings.ToLower(strings.TrimPrefix(key, "X-Riak-Index-"))
+			for _, v := range strings.Split(vals[0], ", ") {
+				c.index = append(c.index, pbPair{key: ikey, value: v})
+			}
+		}
+	}
+	return c, nil
+}
+
+// siblings builds the body of a 300 response
+func siblings(contents []*pbContent) []byte {
+	buf := bytes.NewBufferString("Siblings:\n")
+	for _, c := range contents {
+		buf.WriteString(c.vtag)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// multipartSiblings builds a 300 response with
+// one part per sibling, like riak does when asked
+// for multipart/mixed
+func multipartSiblings(req *http.Request, hdr http.Header, contents []*pbContent) *http.Response {
+	const boundary = "pbsiblingboundary"
+	buf := bytes.NewBuffer(nil)
+	for _, c := range contents {
+		phdr := make(http.Header)
+		c.header(phdr)
+		fmt.Fprintf(buf, "\r\n--%s\r\n", boundary)
+		phdr.Write(buf)
+		buf.WriteString("\r\n")
+		buf.Write(c.value)
+	}
+	fmt.Fprintf(buf, "\r\n--%s--\r\n", boundary)
+	hdr.Set("Content-Type", "multipart/mixed; boundary="+boundary)
+	return pbresponse(req, 300, hdr, buf.Bytes())
+}
+
+// decode an RpbGetResp or RpbPutResp
+func decodeObjectResp(msg []byte) (contents []*pbContent, vclock []byte, key string, unchanged bool, err error) {
+	err = pbfields(msg, func(f int, v uint64, data []byte) error {
+		switch f {
+		case 1:
+			c, err := decodeContent(data)
+			if err != nil {
+				return err
+			}
+			contents = append(contents, c)
+		case 2:
+			vclock = data
+		case 3:
+			// key in RpbPutResp, unchanged in RpbGetResp
+			if data != nil {
+				key = string(data)
+			} else {
+				unchanged = v != 0
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// objectResponse turns the contents of an
+// object into a 200 or 300 response
+func objectResponse(req *http.Request, contents []*pbContent, vclock []byte) *http.Response {
+	hdr := make(http.Header)
+	if vclock != nil {
+		hdr.Set("X-Riak-Vclock", base64.StdEncoding.EncodeToString(vclock))
+	}
+	if len(contents) > 1 {
+		if strings.Contains(req.Header.Get("Accept"), "multipart/mixed") {
+			return multipartSiblings(req, hdr, contents)
+		}
+		hdr.Set("Content-Type", "text/plain")
+		return pbresponse(req, 300, hdr, siblings(contents))
+	}
+	c := contents[0]
+	c.header(hdr)
+	if req.Method == "HEAD" {
+		return pbresponse(req, 200, hdr, nil)
+	}
+	return pbresponse(req, 200, hdr, c.value)
+}
+
+func (t *pbTransport) get(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	q := req.URL.Query()
+	var b pbuf
+	b.str(1, rt.bucket)
+	b.str(2, rt.key)
+	quorum(&b, 3, q.Get("r"))
+	quorum(&b, 4, q.Get("pr"))
+	boolopt(&b, 5, q.Get("basic_quorum"))
+	boolopt(&b, 6, q.Get("notfound_ok"))
+	if req.Header.Get("If-None-Match") != "" {
+		if vc := decodeVclock(req.Header.Get("X-Riak-Vclock")); vc != nil {
+			b.bytes(7, vc)
+		}
+	}
+	if req.Method == "HEAD" {
+		b.bool(8, true)
+	}
+	if rt.btype != "" {
+		b.str(13, rt.btype)
+	}
+
+	msg, err := conn.roundTrip(rpbGetReq, b, rpbGetResp)
+	if err != nil {
+		return nil, err
+	}
+	contents, vclock, _, unchanged, err := decodeObjectResp(msg)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		return pbresponse(req, 304, nil, nil), nil
+	}
+
+	live := contents[:0]
+	for _, c := range contents {
+		if !c.deleted {
+			live = append(live, c)
+		}
+	}
+	if vtag := q.Get("vtag"); vtag != "" {
+		var match []*pbContent
+		for _, c := range live {
+			if c.vtag == vtag {
+				match = []*pbContent{c}
+				break
+			}
+		}
+		live = match
+	}
+	if len(live) == 0 {
+		return pbresponse(req, 404, nil, nil), nil
+	}
+	return objectResponse(req, live, vclock), nil
+}
+
+func (t *pbTransport) put(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	q := req.URL.Query()
+	content, err := t.contentFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	vclock := decodeVclock(req.Header.Get("X-Riak-Vclock"))
+
+	var b pbuf
+	b.str(1, rt.bucket)
+	if rt.key != "" {
+		b.str(2, rt.key)
+	}
+	if vclock != nil {
+		b.bytes(3, vclock)
+	}
+	b.bytes(4, content.encode())
+	quorum(&b, 5, q.Get("w"))
+	quorum(&b, 6, q.Get("dw"))
+	boolopt(&b, 7, q.Get("returnbody"))
+	quorum(&b, 8, q.Get("pw"))
+	if req.Header.Get("If-Match") != "" && vclock != nil {
+		b.bool(9, true)
+	}
+	if req.Header.Get("If-None-Match") == "*" {
+		b.bool(10, true)
+	}
+	if rt.btype != "" {
+		b.str(16, rt.btype)
+	}
+
+	msg, err := conn.roundTrip(rpbPutReq, b, rpbPutResp)
+	if err != nil {
+		return nil, err
+	}
+	contents, vclock, key, _, err := decodeObjectResp(msg)
+	if err != nil {
+		return nil, err
+	}
+	var res *http.Response
+	if len(contents) == 0 {
+		hdr := make(http.Header)
+		if vclock != nil {
+			hdr.Set("X-Riak-Vclock", base64.StdEncoding.EncodeToString(vclock))
+		}
+		res = pbresponse(req, 204, hdr, nil)
+	} else {
+		res = objectResponse(req, contents, vclock)
+	}
+	if key != "" && res.StatusCode != 300 {
+		res.StatusCode = 201
+		res.Status = "201 Created"
+		res.Header.Set("Location", strings.TrimSuffix(req.URL.Path, "/")+"/"+key)
+	}
+	return res, nil
+}
+
+func (t *pbTransport) del(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	q := req.URL.Query()
+	var b pbuf
+	b.str(1, rt.bucket)
+	b.str(2, rt.key)
+	quorum(&b, 3, q.Get("rw"))
+	if vc := decodeVclock(req.Header.Get("X-Riak-Vclock")); vc != nil {
+		b.bytes(4, vc)
+	}
+	quorum(&b, 5, q.Get("r"))
+	quorum(&b, 6, q.Get("w"))
+	quorum(&b, 7, q.Get("pr"))
+	quorum(&b, 8, q.Get("pw"))
+	quorum(&b, 9, q.Get("dw"))
+	if rt.btype != "" {
+		b.str(13, rt.btype)
+	}
+	if _, err := conn.roundTrip(rpbDelReq, b, rpbDelResp); err != nil {
+		return nil, err
+	}
+	return pbresponse(req, 204, nil, nil), nil
+}
+
+func (t *pbTransport) incrCounter(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	q := req.URL.Query()
+	body, err := t.readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return pbresponse(req, 400, nil, nil), nil
+	}
+	var b pbuf
+	b.str(1, rt.bucket)
+	b.str(2, rt.key)
+	b.sint(3, delta)
+	quorum(&b, 4, q.Get("w"))
+	quorum(&b, 5, q.Get("dw"))
+	quorum(&b, 6, q.Get("pw"))
+	ret := q.Get("returnvalue") == "true"
+	if ret {
+		b.bool(7, true)
+	}
+	msg, err := conn.roundTrip(rpbCounterUpdateReq, b, rpbCounterUpdateResp)
+	if err != nil {
+		return nil, err
+	}
+	if !ret {
+		return pbresponse(req, 204, nil, nil), nil
+	}
+	return counterResponse(req, msg)
+}
+
+func (t *pbTransport) getCounter(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	q := req.URL.Query()
+	var b pbuf
+	b.str(1, rt.bucket)
+	b.str(2, rt.key)
+	quorum(&b, 3, q.Get("r"))
+	quorum(&b, 4, q.Get("pr"))
+	boolopt(&b, 5, q.Get("basic_quorum"))
+	boolopt(&b, 6, q.Get("notfound_ok"))
+	msg, err := conn.roundTrip(rpbCounterGetReq, b, rpbCounterGetResp)
+	if err != nil {
+		return nil, err
+	}
+	return counterResponse(req, msg)
+}
+
+// both counter responses have the value in field 1,
+// which is missing if the counter doesn't exist
+func counterResponse(req *http.Request, msg []byte) (*http.Response, error) {
+	var val int64
+	found := false
+	err := pbfields(msg, func(f int, v uint64, data []byte) error {
+		if f == 1 {
+			val, found = unzigzag(v), true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return pbresponse(req, 404, nil, nil), nil
+	}
+	hdr := make(http.Header)
+	hdr.Set("Content-Type", "text/plain")
+	return pbresponse(req, 200, hdr, []byte(strconv.FormatInt(val, 10))), nil
+}
+
+func jsonResponse(req *http.Request, v interface{}) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make(http.Header)
+	hdr.Set("Content-Type", "application/json")
+	return pbresponse(req, 200, hdr, body), nil
+}
+
+func (t *pbTransport) listBuckets(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	var b pbuf
+	if rt.btype != "" {
+		b.str(3, rt.btype)
+	}
+	msg, err := conn.roundTrip(rpbListBucketsReq, b, rpbListBucketsResp)
+	if err != nil {
+		return nil, err
+	}
+	buckets := []string{}
+	err = pbfields(msg, func(f int, v uint64, data []byte) error {
+		if f == 1 {
+			buckets = append(buckets, string(data))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jsonResponse(req, map[string][]string{"buckets": buckets})
+}
+
+func (t *pbTransport) listKeys(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	var b pbuf
+	b.str(1, rt.bucket)
+	if rt.btype != "" {
+		b.str(3, rt.btype)
+	}
+	msg, err := conn.roundTrip(rpbListKeysReq, b, rpbListKeysResp)
+	keys := []string{}
+	for i, done := 0, false; !done; i++ {
+		if i > 0 {
+			msg, err = conn.next(rpbListKeysResp)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = pbfields(msg, func(f int, v uint64, data []byte) error {
+			switch f {
+			case 1:
+				keys = append(keys, string(data))
+			case 2:
+				done = v != 0
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jsonResponse(req, map[string][]string{"keys": keys})
+}
+
+func (t *pbTransport) index(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	q := req.URL.Query()
+	var b pbuf
+	b.str(1, rt.bucket)
+	b.str(2, rt.index)
+	if len(rt.args) == 1 {
+		b.varint(3, 0)
+		b.str(4, rt.args[0])
+	} else {
+		b.varint(3, 1)
+		b.str(5, rt.args[0])
+		b.str(6, rt.args[1])
+	}
+	boolopt(&b, 7, q.Get("return_terms"))
+	if n, err := strconv.ParseUint(q.Get("max_results"), 10, 32); err == nil {
+		b.varint(9, n)
+	}
+	if cont := q.Get("continuation"); cont != "" {
+		b.str(10, cont)
+	}
+	if rt.btype != "" {
+		b.str(12, rt.btype)
+	}
+	if rgx := q.Get("term_regex"); rgx != "" {
+		b.str(13, rgx)
+	}
+
+	msg, err := conn.roundTrip(rpbIndexReq, b, rpbIndexResp)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{})
+	var keys []string
+	var results []map[string]string
+	err = pbfields(msg, func(f int, v uint64, data []byte) error {
+		switch f {
+		case 1:
+			keys = append(keys, string(data))
+		case 2:
+			pr, err := decodePair(data)
+			if err != nil {
+				return err
+			}
+			results = append(results, map[string]string{pr.key: pr.value})
+		case 3:
+			out["continuation"] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if results != nil {
+		out["results"] = results
+	} else {
+		if keys == nil {
+			keys = []string{}
+		}
+		out["keys"] = keys
+	}
+	return jsonResponse(req, out)
+}
+
+func (t *pbTransport) mapred(conn *pbConn, req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return nil, errPBUnsupported
+	}
+	job, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var b pbuf
+	b.bytes(1, job)
+	b.str(2, "application/json")
+	msg, err := conn.roundTrip(rpbMapRedReq, b, rpbMapRedResp)
+
+	// gather the results of each phase in order
+	var phases []int
+	results := make(map[int][]json.RawMessage)
+	for i, done := 0, false; !done; i++ {
+		if i > 0 {
+			msg, err = conn.next(rpbMapRedResp)
+		}
+		if err != nil {
+			return nil, err
+		}
+		phase := -1
+		var data []byte
+		err = pbfields(msg, func(f int, v uint64, d []byte) error {
+			switch f {
+			case 1:
+				phase = int(v)
+			case 2:
+				data = d
+			case 3:
+				done = v != 0
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if phase < 0 || data == nil {
+			continue
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+		if _, ok := results[phase]; !ok {
+			phases = append(phases, phase)
+		}
+		results[phase] = append(results[phase], items...)
+	}
+
+	if req.URL.Query().Get("chunked") == "true" {
+		return mapredChunks(req, phases, results)
+	}
+	// one phase is returned as a flat list;
+	// many phases as a list of lists
+	switch len(phases) {
+	case 0:
+		return jsonResponse(req, []json.RawMessage{})
+	case 1:
+		return jsonResponse(req, results[phases[0]])
+	default:
+		all := make([][]json.RawMessage, len(phases))
+		for i, p := range phases {
+			all[i] = results[p]
+		}
+		return jsonResponse(req, all)
+	}
+}
+
+// mapredChunks builds a multipart response
+// like the one riak sends for chunked=true
+func mapredChunks(req *http.Request, phases []int, results map[int][]json.RawMessage) (*http.Response, error) {
+	const boundary = "pbmapredboundary"
+	buf := bytes.NewBuffer(nil)
+	for _, p := range phases {
+		part, err := json.Marshal(map[string]interface{}{"phase": p, "data": results[p]})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "\r\n--%s\r\nContent-Type: application/json\r\n\r\n", boundary)
+		buf.Write(part)
+	}
+	fmt.Fprintf(buf, "\r\n--%s--\r\n", boundary)
+	hdr := make(http.Header)
+	hdr.Set("Content-Type", "multipart/mixed; boundary="+boundary)
+	return pbresponse(req, 200, hdr, buf.Bytes()), nil
+}
+
+func (t *pbTransport) props(conn *pbConn, req *http.Request, rt route) (*http.Response, error) {
+	switch req.Method {
+	case "GET":
+		var b pbuf
+		code := byte(rpbGetBucketReq)
+		if rt.kind == routeTypeProps {
+			code = rpbGetBucketTypeReq
+			b.str(1, rt.btype)
+		} else {
+			b.str(1, rt.bucket)
+			if rt.btype != "" {
+				b.str(2, rt.btype)
+			}
+		}
+		msg, err := conn.roundTrip(code, b, rpbGetBucketResp)
+		if err != nil {
+			return nil, err
+		}
+		props := make(map[string]interface{})
+		err = pbfields(msg, func(f int, v uint64, data []byte) error {
+			if f == 1 {
+				return decodeProps(data, props)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if rt.kind == routeProps {
+			props["name"] = rt.bucket
+		}
+		return jsonResponse(req, map[string]interface{}{"props": props})
+
+	case "PUT":
+		var body struct {
+			Props map[string]interface{} `json:"props"`
+		}
+		if req.Body != nil {
+			err := json.NewDecoder(req.Body).Decode(&body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		var b pbuf
+		code := byte(rpbSetBucketReq)
+		if rt.kind == routeTypeProps {
+			code = rpbSetBucketTypeReq
+			b.str(1, rt.btype)
+			b.bytes(2, encodeProps(body.Props))
+		} else {
+			b.str(1, rt.bucket)
+			b.bytes(2, encodeProps(body.Props))
+			if rt.btype != "" {
+				b.str(3, rt.btype)
+			}
+		}
+		if _, err := conn.roundTrip(code, b, rpbSetBucketResp); err != nil {
+			return nil, err
+		}
+		return pbresponse(req, 204, nil, nil), nil
+
+	case "DELETE":
+		if rt.kind == routeTypeProps {
+			break
+		}
+		var b pbuf
+		b.str(1, rt.bucket)
+		if rt.btype != "" {
+			b.str(2, rt.btype)
+		}
+		if _, err := conn.roundTrip(rpbResetBucketReq, b, rpbResetBucketResp); err != nil {
+			return nil, err
+		}
+		return pbresponse(req, 204, nil, nil), nil
+	}
+	return nil, errPBUnsupported
+}
+
+const (
+	propUint = iota
+	propBool
+	propString
+	propQuorum
+	propHooks
+	propModFun
+)
+
+// RpbBucketProps fields and their JSON names
+var propFields = []struct {
+	field int
+	name  string
+	kind  int
+}{
+	{1, "n_val", propUint},
+	{2, "allow_mult", propBool},
+	{3, "last_write_wins", propBool},
+	{4, "precommit", propHooks},
+	{6, "postcommit", propHooks},
+	{8, "chash_keyfun", propModFun},
+	{9, "linkfun", propModFun},
+	{10, "old_vclock", propUint},
+	{11, "young_vclock", propUint},
+	{12, "big_vclock", propUint},
+	{13, "small_vclock", propUint},
+	{14, "pr", propQuorum},
+	{15, "r", propQuorum},
+	{16, "w", propQuorum},
+	{17, "pw", propQuorum},
+	{18, "dw", propQuorum},
+	{19, "rw", propQuorum},
+	{20, "basic_quorum", propBool},
+	{21, "notfound_ok", propBool},
+	{22, "backend", propString},
+	{23, "search", propBool},
+	{25, "search_index", propString},
+	{26, "datatype", propString},
+	{27, "consistent", propBool},
+	{28, "write_once", propBool},
+}
+
+func decodeModFun(p []byte) (map[string]interface{}, error) {
+	mf := make(map[string]interface{})
+	err := pbfields(p, func(f int, v uint64, data []byte) error {
+		switch f {
+		case 1:
+			mf["mod"] = string(data)
+		case 2:
+			mf["fun"] = string(data)
+		}
+		return nil
+	})
+	return mf, err
+}
+
+func encodeModFun(v interface{}) []byte {
+	var b pbuf
+	m, _ := v.(map[string]interface{})
+	mod, _ := m["mod"].(string)
+	fun, _ := m["fun"].(string)
+	b.str(1, mod)
+	b.str(2, fun)
+	return b
+}
+
+// decode RpbBucketProps into the JSON
+// representation used by the HTTP interface
+func decodeProps(p []byte, props map[string]interface{}) error {
+	byField := make(map[int]int, len(propFields))
+	for i, pf := range propFields {
+		byField[pf.field] = i
+	}
+	return pbfields(p, func(f int, v uint64, data []byte) error {
+		switch f {
+		case 5, 7:
+			// has_precommit and has_postcommit
+			name := "precommit"
+			if f == 7 {
+				name = "postcommit"
+			}
+			if _, ok := props[name]; !ok && v != 0 {
+				props[name] = []interface{}{}
+			}
+			return nil
+		}
+		i, ok := byField[f]
+		if !ok {
+			return nil
+		}
+		pf := propFields[i]
+		switch pf.kind {
+		case propUint:
+			props[pf.name] = v
+		case propBool:
+			props[pf.name] = v != 0
+		case propString:
+			props[pf.name] = string(data)
+		case propQuorum:
+			props[pf.name] = quorumName(v)
+		case propModFun:
+			mf, err := decodeModFun(data)
+			if err != nil {
+				return err
+			}
+			props[pf.name] = mf
+		case propHooks:
+			hook := make(map[string]interface{})
+			err := pbfields(data, func(f int, v uint64, data []byte) error {
+				switch f {
+				case 1:
+					mf, err := decodeModFun(data)
+					if err != nil {
+						return err
+					}
+					for k, v := range mf {
+						hook[k] = v
+					}
+				case 2:
+					hook["name"] = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			hooks, _ := props[pf.name].([]interface{})
+			props[pf.name] = append(hooks, hook)
+		}
+		return nil
+	})
+}
+
+// encode the JSON representation
+// of bucket props as RpbBucketProps
+func encodeProps(props map[string]interface{}) []byte {
+	var b pbuf
+	for _, pf := range propFields {
+		v, ok := props[pf.name]
+		if !ok || v == nil {
+			continue
+		}
+		switch pf.kind {
+		case propUint:
+			if n, ok := v.(float64); ok {
+				b.varint(pf.field, uint64(n))
+			}
+		case propBool:
+			if t, ok := v.(bool); ok {
+				b.bool(pf.field, t)
+			}
+		case propString:
+			if s, ok := v.(string); ok {
+				b.str(pf.field, s)
+			}
+		case propQuorum:
+			switch q := v.(type) {
+			case string:
+				quorum(&b, pf.field, q)
+			case float64:
+				b.varint(pf.field, uint64(q))
+			}
+		case propModFun:
+			b.bytes(pf.field, encodeModFun(v))
+		case propHooks:
+			hooks, _ := v.([]interface{})
+			for _, h := range hooks {
+				var hb pbuf
+				m, _ := h.(map[string]interface{})
+				if name, ok := m["name"].(string); ok {
+					hb.str(2, name)
+				} else {
+					hb.bytes(1, encodeModFun(m))
+				}
+				b.bytes(pf.field, hb)
+			}
+			// has_precommit / has_postcommit
+			b.bool(pf.field+1, true)
+		}
+	}
+	return b
+}
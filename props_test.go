@@ -0,0 +1,200 @@
+package riak
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const defaultProps = `{"name":"users","n_val":3,"allow_mult":false,"last_write_wins":false,
+"precommit":[{"mod":"validate","fun":"check"}],"postcommit":[{"name":"Riak.notify"}],
+"chash_keyfun":{"mod":"riak_core_util","fun":"chash_std_keyfun"},
+"linkfun":{"mod":"riak_kv_wm_link_walker","fun":"mapreduce_linkfun"},
+"old_vclock":86400,"young_vclock":20,"big_vclock":50,"small_vclock":50,
+"pr":0,"r":"quorum","w":"quorum","pw":0,"dw":"quorum","rw":"quorum",
+"basic_quorum":false,"notfound_ok":true,"backend":"leveldb","search":false,
+"search_index":"users_idx","dvv_enabled":true,"hll_precision":14}`
+
+// serves props for any bucket, recording the props of each PUT
+type propServer struct {
+	sync.Mutex
+	props map[string]json.RawMessage
+	puts  []map[string]json.RawMessage
+}
+
+func newPropServer() *propServer {
+	ps := &propServer{props: make(map[string]json.RawMessage)}
+	json.Unmarshal([]byte(defaultProps), &ps.props)
+	return ps
+}
+
+func (ps *propServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.Lock()
+	defer ps.Unlock()
+	if !strings.HasSuffix(r.URL.Path, "/props") {
+		w.WriteHeader(404)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"props": ps.props})
+	case "PUT":
+		var body struct {
+			Props map[string]json.RawMessage `json:"props"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Props == nil {
+			w.WriteHeader(400)
+			return
+		}
+		ps.puts = append(ps.puts, body.Props)
+		for k, v := range body.Props {
+			ps.props[k] = v
+		}
+		w.WriteHeader(204)
+	}
+}
+
+func TestBucketProps(t *testing.T) {
+	ps := newPropServer()
+	srv := httptest.NewServer(ps)
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	p, err := c.GetBucketProps("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "users" || p.Nval != 3 || !p.NotFoundOK || !p.DVV || p.Backend != "leveldb" {
+		t.Errorf("bad props %+v", p)
+	}
+	if p.R != "quorum" || p.PR != "0" || p.YoungV != 20 || p.OldV != 86400 {
+		t.Errorf("bad quorums or vclock pruning %+v", p)
+	}
+	if len(p.Precommit) != 1 || p.Precommit[0] != (Hook{Mod: "validate", Fun: "check"}) {
+		t.Errorf("bad precommit %v", p.Precommit)
+	}
+	if len(p.Postcommit) != 1 || p.Postcommit[0] != (Hook{Name: "Riak.notify"}) {
+		t.Errorf("bad postcommit %v", p.Postcommit)
+	}
+	if p.Link != (ModFun{Mod: "riak_kv_wm_link_walker", Fun: "mapreduce_linkfun"}) {
+		t.Errorf("bad linkfun %v", p.Link)
+	}
+	if p.SearchIndex != "users_idx" {
+		t.Errorf("bad search_index %q", p.SearchIndex)
+	}
+	if string(p.Extra["hll_precision"]) != "14" {
+		t.Errorf("unknown property not preserved: %v", p.Extra)
+	}
+
+	// nothing changed; no request
+	if err := c.SetBucketProps("users", p); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.puts) != 0 {
+		t.Errorf("expected no requests; got %v", ps.puts)
+	}
+
+	// only the changes are sent, including zero values
+	p.Mult = true
+	p.NotFoundOK = false
+	p.W = "2"
+	p.Postcommit = nil
+	if err := c.SetBucketProps("users", p); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.puts) != 1 {
+		t.Fatalf("expected 1 request; got %d", len(ps.puts))
+	}
+	put := ps.puts[0]
+	if len(put) != 4 || string(put["allow_mult"]) != "true" || string(put["notfound_ok"]) != "false" ||
+		string(put["w"]) != "2" || string(put["postcommit"]) != "[]" {
+		t.Errorf("bad partial update %s", jsonString(put))
+	}
+	// and sent only once
+	if err := c.SetBucketProps("users", p); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.puts) != 1 {
+		t.Errorf("changes were sent twice")
+	}
+
+	// unfetched props only send non-zero values
+	fresh := &BucketProps{Nval: 5, Extra: map[string]json.RawMessage{"hll_precision": json.RawMessage("16")}}
+	if err := c.SetBucketProps("users", fresh); err != nil {
+		t.Fatal(err)
+	}
+	put = ps.puts[len(ps.puts)-1]
+	if len(put) != 2 || string(put["n_val"]) != "5" || string(put["hll_precision"]) != "16" {
+		t.Errorf("bad update %s", jsonString(put))
+	}
+	// zero values have to be named
+	if err := c.SetBucketProps("users", &BucketProps{Mult: false}); err != errNoProps {
+		t.Errorf("expected errNoProps; got %v", err)
+	}
+	if err := c.SetBucketProps("users", &BucketProps{Zero: []string{"allow_mul"}}); err == nil {
+		t.Error("expected an error for an unknown property")
+	}
+	if err := c.SetBucketProps("users", &BucketProps{Nval: 5, Zero: []string{"allow_mult", "last_write_wins"}}); err != nil {
+		t.Fatal(err)
+	}
+	put = ps.puts[len(ps.puts)-1]
+	if len(put) != 3 || string(put["allow_mult"]) != "false" || string(put["last_write_wins"]) != "false" {
+		t.Errorf("bad update %s", jsonString(put))
+	}
+	ps.props["allow_mult"] = json.RawMessage("true")
+
+	// everything round-trips
+	p, err = c.GetBucketProps("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Nval != 5 || !p.Mult || p.NotFoundOK || p.W != "2" || len(p.Postcommit) != 0 || string(p.Extra["hll_precision"]) != "16" {
+		t.Errorf("bad props after update %+v", p)
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all map[string]json.RawMessage
+	json.Unmarshal(b, &all)
+	if string(all["hll_precision"]) != "16" || string(all["w"]) != "2" || string(all["r"]) != `"quorum"` {
+		t.Errorf("bad encoding %s", b)
+	}
+}
+
+func TestPBBucketProps(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 1))
+	defer c.Close()
+
+	err := c.SetBucketProps("users", &BucketProps{
+		Nval:      5,
+		Mult:      true,
+		R:         "quorum",
+		W:         "2",
+		Precommit: []Hook{{Mod: "validate", Fun: "check"}, {Name: "Riak.validate"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.GetBucketProps("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "users" || p.Nval != 5 || !p.Mult || p.R != "quorum" || p.W != "2" {
+		t.Errorf("bad props %+v", p)
+	}
+	if len(p.Precommit) != 2 || p.Precommit[0].Mod != "validate" || p.Precommit[1].Name != "Riak.validate" {
+		t.Errorf("bad hooks %+v", p.Precommit)
+	}
+}
+
+func jsonString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
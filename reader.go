@@ -0,0 +1,133 @@
+package riak
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// FetchReader is like Fetch, but instead of reading the object's
+// body into Body (which is left empty), it returns the body as
+// it's read off the connection, so that large values don't have
+// to be held in memory. The caller must close the body. Siblings
+// are returned as ErrMultipleVclocks, even if a Resolver has been
+// registered for the bucket.
+//
+//	o, body, err := c.FetchReader("videos", "intro.mp4", nil)
+//	if err != nil {
+//		...
+//	}
+//	defer body.Close()
+//	w.Header().Set("Content-Type", o.Ctype)
+//	io.Copy(w, body)
+func (c *Client) FetchReader(bucket string, key string, opts map[string]string) (*Object, io.ReadCloser, error) {
+	return c.FetchReaderContext(context.Background(), bucket, key, opts)
+}
+
+// FetchReaderContext is like FetchReader, but the request is abandoned,
+// and reads of the body fail, if 'ctx' is done before it completes.
+func (c *Client) FetchReaderContext(ctx context.Context, bucket string, key string, opts map[string]string) (*Object, io.ReadCloser, error) {
+	o := newObj()
+	o.Bucket = bucket
+	o.Key = key
+	o.BucketType = c.btype
+	req, err := c.newreq(ctx, "GET", c.objpath(o), nil)
+	if err != nil {
+		Release(o)
+		return nil, nil, err
+	}
+	if opts != nil {
+		query := make(url.Values)
+		for key, val := range opts {
+			query.Set(key, val)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	res, err := c.send(req)
+	if err != nil {
+		Release(o)
+		return nil, nil, err
+	}
+	if res.StatusCode == 200 {
+		if err := o.fromResponse(res.Header, nil); err != nil {
+			res.Body.Close()
+			Release(o)
+			return nil, nil, err
+		}
+		return o, res.Body, nil
+	}
+	Release(o)
+	if res.StatusCode == 300 {
+		// multiple closes the body
+		return nil, nil, multiple(res)
+	}
+	return nil, nil, streamStatus(res)
+}
+
+// StoreReader is like Store, but it sends the object's body from
+// 'body' instead of Body, so that large values don't have to be
+// held in memory. If 'size' isn't negative, it must be the exact
+// length of the body; otherwise the body is sent chunked. Unlike
+// Store, StoreReader doesn't ask riak to return the stored object,
+// so the object's Vclock and Etag are only updated if riak sends
+// them; use Head to get them otherwise. Over protocol buffers,
+// the body is read into memory before it's sent, since values
+// can't be streamed; see PBMaxValue.
+func (c *Client) StoreReader(o *Object, body io.Reader, size int64, opts map[string]string) error {
+	return c.StoreReaderContext(context.Background(), o, body, size, opts)
+}
+
+// StoreReaderContext is like StoreReader, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) StoreReaderContext(ctx context.Context, o *Object, body io.Reader, size int64, opts map[string]string) error {
+	if size == 0 {
+		body = http.NoBody
+	}
+	req, err := c.newreq(ctx, "PUT", c.objpath(o), body)
+	if err != nil {
+		return err
+	}
+	// newreq only knows the length of in-memory bodies
+	if size > 0 {
+		req.ContentLength = size
+	} else if size < 0 {
+		req.ContentLength = -1
+	}
+	if opts != nil {
+		query := make(url.Values)
+		for key, val := range opts {
+			query.Set(key, val)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+	o.writeheader(req.Header)
+
+	// writes aren't retried, so the body is only read once
+	res, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case 200, 201, 204:
+		if vc := res.Header.Get("X-Riak-Vclock"); vc != "" {
+			o.Vclock = vc
+		}
+		if etag := res.Header.Get("Etag"); etag != "" {
+			o.eTag = etag
+		}
+		return nil
+	case 300:
+		return &ErrMultipleVclocks{}
+	case 400:
+		return ErrBadRequest
+	case 404:
+		return ErrNotFound
+	case 412:
+		return ErrModified
+	default:
+		return statusCode(res.StatusCode)
+	}
+}
@@ -0,0 +1,108 @@
+package riak
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/philhofer/riak/riaktest"
+)
+
+func TestStreaming(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	var lengths []int64
+	c := NewClient(srv.URL, "testClient", WrapTransport(func(tr Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method == "PUT" {
+				lengths = append(lengths, req.ContentLength)
+			}
+			return tr.Do(req)
+		})
+	}))
+
+	body := strings.Repeat("0123456789", 100000)
+	o := &Object{Bucket: "blobs", Key: "big", Ctype: "text/plain"}
+	o.Meta = map[string]string{"Owner": "alice"}
+	o.AddIndex("size_int", "1000000")
+	// a reader that newreq can't measure
+	if err := c.StoreReader(o, io.MultiReader(strings.NewReader(body)), int64(len(body)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if o.Meta["Owner"] != "alice" || o.GetIndex("size_int") != "1000000" {
+		t.Errorf("StoreReader clobbered the object's metadata: %+v", o)
+	}
+
+	h, body2, err := c.FetchReader("blobs", "big", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Body.Len() != 0 || h.Ctype != "text/plain" || h.Meta["Owner"] != "alice" || h.Vclock == "" {
+		t.Errorf("bad object %+v", h)
+	}
+	b, err := io.ReadAll(body2)
+	body2.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("read %d bytes; expected %d", len(b), len(body))
+	}
+	// the buffered path sees the same thing
+	if o, err := c.Fetch("blobs", "big", nil); err != nil || o.Body.String() != body {
+		t.Errorf("Fetch after StoreReader: %v", err)
+	}
+
+	// unknown length
+	h.Ctype = "application/octet-stream"
+	if err := c.StoreReader(h, io.MultiReader(strings.NewReader("chunked")), -1, nil); err != nil {
+		t.Fatal(err)
+	}
+	// empty
+	if err := c.StoreReader(&Object{Bucket: "blobs", Key: "empty"}, nil, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{int64(len(body)), -1, 0}
+	if len(lengths) != len(want) {
+		t.Fatalf("expected PUTs with lengths %v; got %v", want, lengths)
+	}
+	for i := range want {
+		if lengths[i] != want[i] {
+			t.Errorf("expected PUTs with lengths %v; got %v", want, lengths)
+			break
+		}
+	}
+
+	_, r, err := c.FetchReader("blobs", "big", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = io.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "chunked" {
+		t.Errorf("read %q %v", b, err)
+	}
+	// closing before reading everything is fine
+	_, r, err = c.FetchReader("blobs", "big", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if _, _, err := c.FetchReader("blobs", "missing", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	// siblings
+	if err := c.SetBucketProps("blobs", &BucketProps{Mult: true}); err != nil {
+		t.Fatal(err)
+	}
+	c.Store(&Object{Bucket: "blobs", Key: "big", Body: bytes.NewBufferString("conflict")}, nil)
+	if _, _, err := c.FetchReader("blobs", "big", nil); err == nil {
+		t.Error("expected an error for siblings")
+	} else if _, ok := err.(*ErrMultipleVclocks); !ok {
+		t.Errorf("expected ErrMultipleVclocks; got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ReconcileOptions control ReconcileBuckets
+type ReconcileOptions struct {
+	// DryRun computes the plan without changing anything
+	DryRun bool
+
+	// AllowNvalDecrease permits lowering n_val, which
+	// leaves fewer replicas of existing data
+	AllowNvalDecrease bool
+
+	// AllowMultDisable permits turning allow_mult off, after
+	// which riak discards siblings on the next write
+	AllowMultDisable bool
+
+	// AllowBackendChange permits changing the backend, which
+	// hides the objects that are stored in the old one
+	AllowBackendChange bool
+
+	// Zero names properties that are reconciled even when
+	// they are zero in the desired props, e.g. "allow_mult"
+	// in order to turn allow_mult off.
+	Zero []string
+}
+
+// PropChange is a change of one property of one bucket
+type PropChange struct {
+	Bucket string
+	Prop   string
+	Old    json.RawMessage
+	New    json.RawMessage
+}
+
+func (p PropChange) String() string {
+	return fmt.Sprintf("%s: %s %s -> %s", p.Bucket, p.Prop, p.Old, p.New)
+}
+
+// Plan is the list of changes that ReconcileBuckets
+// makes, ordered by bucket and then by property.
+type Plan []PropChange
+
+// String returns one line per change, e.g.
+//
+//	users: allow_mult false -> true
+//	users: n_val 3 -> 5
+func (p Plan) String() string {
+	if len(p) == 0 {
+		return "no changes\n"
+	}
+	buf := bytes.NewBuffer(nil)
+	for _, c := range p {
+		buf.WriteString(c.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// ErrUnsafeChange is returned when reconciliation would
+// make a change that it wasn't allowed to make: lowering
+// n_val, turning allow_mult off, or changing the backend.
+type ErrUnsafeChange struct {
+	Change PropChange
+}
+
+func (e *ErrUnsafeChange) Error() string {
+	return fmt.Sprintf("riak: refusing to change %s of bucket %s from %s to %s",
+		e.Change.Prop, e.Change.Bucket, e.Change.Old, e.Change.New)
+}
+
+// ReconcileBuckets makes the properties of each bucket in 'desired'
+// match the desired ones, and returns the changes that it made.
+// Only the non-zero desired properties (and those named in opts.Zero
+// or in their Zero) are considered, and only the properties that differ are set.
+// Every change is planned before any is made, so an unsafe change
+// (see ErrUnsafeChange) means that nothing is changed. Every
+// desired props must be non-nil. 'opts' may be nil.
+func (c *Client) ReconcileBuckets(desired map[string]*BucketProps, opts *ReconcileOptions) (Plan, error) {
+	return c.ReconcileBucketsContext(context.Background(), desired, opts)
+}
+
+// ReconcileBucketsContext is like ReconcileBuckets, but the requests
+// are abandoned if 'ctx' is done before they complete.
+func (c *Client) ReconcileBucketsContext(ctx context.Context, desired map[string]*BucketProps, opts *ReconcileOptions) (Plan, error) {
+	if opts == nil {
+		opts = &ReconcileOptions{}
+	}
+	zero := make(map[string]bool, len(opts.Zero))
+	for _, name := range opts.Zero {
+		zero[name] = true
+	}
+	buckets := make([]string, 0, len(desired))
+	for b := range desired {
+		buckets = append(buckets, b)
+	}
+	sort.Strings(buckets)
+
+	var plan Plan
+	for _, b := range buckets {
+		if desired[b] == nil {
+			return nil, fmt.Errorf("riak: no desired props for bucket %s", b)
+		}
+		cur, err := c.GetBucketPropsContext(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		want, err := desired[b].fields()
+		if err != nil {
+			return nil, err
+		}
+		var props []string
+		for name, v := range want {
+			if name == "name" {
+				continue
+			}
+			if bytes.Equal(v, zeroProps[name]) && !zero[name] && !named(desired[b].Zero, name) {
+				continue
+			}
+			if bytes.Equal(v, cur.fetched[name]) {
+				continue
+			}
+			props = append(props, name)
+		}
+		sort.Strings(props)
+		for _, name := range props {
+			ch := PropChange{Bucket: b, Prop: name, Old: cur.fetched[name], New: want[name]}
+			if ch.Old == nil {
+				ch.Old = json.RawMessage("null")
+			}
+			if !opts.safe(ch) {
+				return nil, &ErrUnsafeChange{Change: ch}
+			}
+			plan = append(plan, ch)
+		}
+	}
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	for i := 0; i < len(plan); {
+		b := plan[i].Bucket
+		changes := make(map[string]json.RawMessage)
+		for ; i < len(plan) && plan[i].Bucket == b; i++ {
+			changes[plan[i].Prop] = plan[i].New
+		}
+		if err := c.putProps(ctx, bucketPath(c.btype, b)+"/props", changes); err != nil {
+			return plan, fmt.Errorf("riak: setting props of %s: %s", b, err)
+		}
+	}
+	return plan, nil
+}
+
+// whether 'opts' allow the change
+func (opts *ReconcileOptions) safe(ch PropChange) bool {
+	switch ch.Prop {
+	case "n_val":
+		return opts.AllowNvalDecrease || !lower(ch.Old, ch.New)
+	case "allow_mult":
+		return opts.AllowMultDisable || string(ch.Old) != "true"
+	case "backend":
+		return opts.AllowBackendChange
+	}
+	return true
+}
+
+func named(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the number 'new' is lower than 'old'
+func lower(old json.RawMessage, new json.RawMessage) bool {
+	o, err := strconv.ParseInt(string(old), 10, 64)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.ParseInt(string(new), 10, 64)
+	return err == nil && n < o
+}
@@ -0,0 +1,117 @@
+package riak
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReconcileBuckets(t *testing.T) {
+	ps := newPropServer()
+	srv := httptest.NewServer(ps)
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	desired := map[string]*BucketProps{
+		"users": {Nval: 5, W: "all", Backend: "leveldb"},
+	}
+
+	// dry runs change nothing
+	plan, err := c.ReconcileBuckets(desired, &ReconcileOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "users: n_val 3 -> 5\nusers: w \"quorum\" -> \"all\"\n"
+	if plan.String() != want {
+		t.Errorf("expected plan\n%sgot\n%s", want, plan)
+	}
+	if len(ps.puts) != 0 {
+		t.Fatalf("dry run made changes: %v", ps.puts)
+	}
+
+	plan, err = c.ReconcileBuckets(desired, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || len(ps.puts) != 1 {
+		t.Fatalf("expected 2 changes in 1 request; got %v in %d", plan, len(ps.puts))
+	}
+	put := ps.puts[0]
+	if len(put) != 2 || string(put["n_val"]) != "5" || string(put["w"]) != `"all"` {
+		t.Errorf("bad update %s", jsonString(put))
+	}
+
+	// reconciled buckets need no changes
+	plan, err = c.ReconcileBuckets(desired, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 0 || plan.String() != "no changes\n" || len(ps.puts) != 1 {
+		t.Errorf("expected no changes; got %v", plan)
+	}
+
+	// zero values are only reconciled when asked for
+	desired["users"].NotFoundOK = false
+	plan, err = c.ReconcileBuckets(desired, &ReconcileOptions{Zero: []string{"notfound_ok"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].Prop != "notfound_ok" || string(plan[0].New) != "false" {
+		t.Errorf("bad plan %v", plan)
+	}
+	// ... or named in the props
+	desired["users"].Zero = []string{"dvv_enabled"}
+	plan, err = c.ReconcileBuckets(desired, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].Prop != "dvv_enabled" || string(plan[0].New) != "false" {
+		t.Errorf("bad plan %v", plan)
+	}
+
+	// lowering n_val is refused, and nothing is changed
+	desired["users"].Nval = 2
+	desired["users"].Mult = true
+	puts := len(ps.puts)
+	_, err = c.ReconcileBuckets(desired, nil)
+	if uerr, ok := err.(*ErrUnsafeChange); !ok || uerr.Change.Prop != "n_val" {
+		t.Fatalf("expected an unsafe change error; got %v", err)
+	}
+	if len(ps.puts) != puts {
+		t.Error("unsafe plan was partially applied")
+	}
+	plan, err = c.ReconcileBuckets(desired, &ReconcileOptions{AllowNvalDecrease: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || string(ps.props["n_val"]) != "2" || string(ps.props["allow_mult"]) != "true" {
+		t.Errorf("bad plan %v; props %s", plan, jsonString(ps.props))
+	}
+
+	// as are turning allow_mult off and changing the backend
+	unsafe := map[string]*BucketProps{
+		"allow_mult": {Zero: []string{"allow_mult"}},
+		"backend":    {Backend: "bitcask"},
+	}
+	for prop, props := range unsafe {
+		_, err := c.ReconcileBuckets(map[string]*BucketProps{"users": props}, nil)
+		if uerr, ok := err.(*ErrUnsafeChange); !ok || uerr.Change.Prop != prop {
+			t.Errorf("expected an unsafe change to %s; got %v", prop, err)
+		}
+	}
+	plan, err = c.ReconcileBuckets(map[string]*BucketProps{"users": {Backend: "bitcask", Zero: []string{"allow_mult"}}},
+		&ReconcileOptions{AllowMultDisable: true, AllowBackendChange: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || string(ps.props["allow_mult"]) != "false" || string(ps.props["backend"]) != `"bitcask"` {
+		t.Errorf("bad plan %v; props %s", plan, jsonString(ps.props))
+	}
+	if len(ps.puts) != puts+2 {
+		t.Error("unsafe plans were applied")
+	}
+
+	// nil props are an error, not a panic
+	if _, err := c.ReconcileBuckets(map[string]*BucketProps{"users": nil}, nil); err == nil {
+		t.Error("expected an error for nil props")
+	}
+}
@@ -0,0 +1,268 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// Resolver chooses or constructs a single object out of
+// a set of siblings. The returned object is written back
+// to riak with the siblings' vector clock, which resolves
+// the conflict. The siblings have the same bucket, key and
+// vector clock. Resolvers may modify and return one of the
+// siblings.
+type Resolver func(siblings []*Object) *Object
+
+// WithResolver registers a Resolver for 'bucket' in the default
+// bucket type. When Fetch encounters siblings in that bucket, it
+// resolves them with 'r', stores the result, and returns it instead
+// of ErrMultipleVclocks. If 'r' returns nil, Fetch returns
+// ErrMultipleVclocks.
+func WithResolver(bucket string, r Resolver) Option {
+	return WithTypeResolver("", bucket, r)
+}
+
+// WithTypeResolver is like WithResolver, but it registers 'r' for
+// 'bucket' in the bucket type 'btype', which is used by clients
+// returned by BucketType(btype).
+func WithTypeResolver(btype string, bucket string, r Resolver) Option {
+	return func(c *Client) {
+		if c.resolvers == nil {
+			c.resolvers = make(map[resolverKey]Resolver)
+		}
+		c.resolvers[newResolverKey(btype, bucket)] = r
+	}
+}
+
+type resolverKey struct {
+	btype  string
+	bucket string
+}
+
+func newResolverKey(btype string, bucket string) resolverKey {
+	if btype == "default" {
+		btype = ""
+	}
+	return resolverKey{btype: btype, bucket: bucket}
+}
+
+// the resolver registered for 'bucket'
+// in the client's bucket type, if any
+func (c *Client) resolver(bucket string) Resolver {
+	return c.resolvers[newResolverKey(c.btype, bucket)]
+}
+
+// FetchSiblings gets every sibling stored at bucket/key.
+// If there are no conflicts, it returns one object. Valid
+// options are the same as for Fetch.
+func (c *Client) FetchSiblings(bucket string, key string, opts map[string]string) ([]*Object, error) {
+	return c.FetchSiblingsContext(context.Background(), bucket, key, opts)
+}
+
+// FetchSiblingsContext is like FetchSiblings, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) FetchSiblingsContext(ctx context.Context, bucket string, key string, opts map[string]string) ([]*Object, error) {
+	path := (&Object{Bucket: bucket, Key: key, BucketType: c.btype}).path()
+	req, err := c.newreq(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil {
+		query := make(url.Values)
+		for key, val := range opts {
+			query.Set(key, val)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+	// a bare multipart/mixed would get 406 for single values
+	req.Header.Set("Accept", "multipart/mixed, */*;q=0.5")
+
+	res, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case 200:
+		o := newObj()
+		o.Bucket, o.Key, o.BucketType = bucket, key, c.btype
+		if err := o.fromResponse(res.Header, res.Body); err != nil {
+			Release(o)
+			return nil, err
+		}
+		return []*Object{o}, nil
+
+	case 300:
+		mtype, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if err != nil || !strings.HasPrefix(mtype, "multipart/") {
+			// multiple closes the body
+			return nil, multiple(res)
+		}
+		vclock := res.Header.Get("X-Riak-Vclock")
+		var objs []*Object
+		mpr := multipart.NewReader(res.Body, params["boundary"])
+		for {
+			part, err := mpr.NextPart()
+			if err != nil {
+				res.Body.Close()
+				if err == io.EOF {
+					return objs, nil
+				}
+				for _, o := range objs {
+					Release(o)
+				}
+				return nil, err
+			}
+			o := newObj()
+			err = o.fromResponse(part.Header, part)
+			if err != nil {
+				res.Body.Close()
+				Release(o)
+				for _, o := range objs {
+					Release(o)
+				}
+				return nil, err
+			}
+			o.Bucket, o.Key, o.BucketType, o.Vclock = bucket, key, c.btype, vclock
+			objs = append(objs, o)
+		}
+
+	case 400:
+		res.Body.Close()
+		return nil, ErrBadRequest
+
+	case 404:
+		res.Body.Close()
+		return nil, ErrNotFound
+
+	case 503:
+		res.Body.Close()
+		return nil, ErrTimeout
+
+	default:
+		res.Body.Close()
+		return nil, statusCode(res.StatusCode)
+	}
+}
+
+// FetchResolve fetches the object at bucket/key, using 'r' to
+// resolve any siblings. The resolved object is stored back into
+// riak before it is returned.
+func (c *Client) FetchResolve(bucket string, key string, opts map[string]string, r Resolver) (*Object, error) {
+	return c.FetchResolveContext(context.Background(), bucket, key, opts, r)
+}
+
+// FetchResolveContext is like FetchResolve, but the requests are
+// abandoned if 'ctx' is done before they complete.
+func (c *Client) FetchResolveContext(ctx context.Context, bucket string, key string, opts map[string]string, r Resolver) (*Object, error) {
+	sibs, err := c.FetchSiblingsContext(ctx, bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(sibs) == 1 {
+		return sibs[0], nil
+	}
+	return c.resolve(ctx, sibs, r)
+}
+
+// resolve resolves siblings and writes back the result
+func (c *Client) resolve(ctx context.Context, sibs []*Object, r Resolver) (*Object, error) {
+	o, err := resolveSiblings(sibs, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.StoreContext(ctx, o, nil); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// resolveSiblings calls 'r' and gives its result the siblings'
+// bucket, key and vector clock. The other siblings are released.
+// If 'r' returns nil, the siblings are returned as an error.
+func resolveSiblings(sibs []*Object, r Resolver) (*Object, error) {
+	bucket, key, btype, vclock := sibs[0].Bucket, sibs[0].Key, sibs[0].BucketType, sibs[0].Vclock
+	tags := siblingTags(sibs)
+	o := r(sibs)
+	for _, s := range sibs {
+		if s != o {
+			Release(s)
+		}
+	}
+	if o == nil {
+		return nil, &ErrMultipleVclocks{Vclocks: tags}
+	}
+	o.Bucket, o.Key, o.BucketType, o.Vclock = bucket, key, btype, vclock
+	return o, nil
+}
+
+func siblingTags(sibs []*Object) []string {
+	tags := make([]string, len(sibs))
+	for i, s := range sibs {
+		tags[i] = strings.Trim(s.eTag, `"`)
+	}
+	return tags
+}
+
+// LastModifiedWins is a Resolver that picks
+// the most recently modified sibling.
+func LastModifiedWins(siblings []*Object) *Object {
+	best := siblings[0]
+	for _, o := range siblings[1:] {
+		if o.lastModified.After(best.lastModified) {
+			best = o
+		}
+	}
+	return best
+}
+
+// UnionJSONArrays is a Resolver for objects whose bodies are
+// JSON arrays used as sets. It returns an object whose body is
+// the union of the elements of every sibling, in order of first
+// appearance. Siblings that aren't JSON arrays are ignored; if
+// none of them are, it falls back to LastModifiedWins.
+func UnionJSONArrays(siblings []*Object) *Object {
+	var out *Object
+	var union []json.RawMessage
+	seen := make(map[string]struct{})
+	for _, o := range siblings {
+		if o.Body == nil {
+			continue
+		}
+		var elems []json.RawMessage
+		if err := json.Unmarshal(o.Body.Bytes(), &elems); err != nil {
+			continue
+		}
+		if out == nil {
+			out = o
+		}
+		for _, e := range elems {
+			// compact so that formatting doesn't matter
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, e); err != nil {
+				continue
+			}
+			if _, ok := seen[buf.String()]; ok {
+				continue
+			}
+			seen[buf.String()] = struct{}{}
+			union = append(union, json.RawMessage(buf.Bytes()))
+		}
+	}
+	if out == nil {
+		return LastModifiedWins(siblings)
+	}
+	if union == nil {
+		union = []json.RawMessage{}
+	}
+	body, _ := json.Marshal(union)
+	out.Body.Reset()
+	out.Body.Write(body)
+	out.Ctype = "application/json"
+	return out
+}
@@ -0,0 +1,167 @@
+package riak
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const siblingBody = "\r\n--sib\r\n" +
+	"Content-Type: application/json\r\nEtag: one\r\nLast-Modified: Mon, 02 Jan 2006 15:04:05 GMT\r\n\r\n" +
+	"[1, 2]" +
+	"\r\n--sib\r\n" +
+	"Content-Type: application/json\r\nEtag: two\r\nLast-Modified: Tue, 03 Jan 2006 15:04:05 GMT\r\n\r\n" +
+	"[2,3]" +
+	"\r\n--sib--\r\n"
+
+// serves two siblings until something is PUT
+func siblingServer(t *testing.T, stored *bytes.Buffer, vclock *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if stored.Len() > 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(stored.Bytes())
+				return
+			}
+			w.Header().Set("X-Riak-Vclock", "siblingclock")
+			if !strings.Contains(r.Header.Get("Accept"), "multipart/mixed") {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(300)
+				io.WriteString(w, "Siblings:\none\ntwo\n")
+				return
+			}
+			w.Header().Set("Content-Type", "multipart/mixed; boundary=sib")
+			w.WriteHeader(300)
+			io.WriteString(w, siblingBody)
+		case "PUT":
+			*vclock = r.Header.Get("X-Riak-Vclock")
+			io.Copy(stored, r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Riak-Vclock", "resolvedclock")
+			w.Write(stored.Bytes())
+		}
+	}))
+}
+
+func TestMultipleVtags(t *testing.T) {
+	var stored bytes.Buffer
+	var vclock string
+	srv := siblingServer(t, &stored, &vclock)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	_, err := c.Fetch("testing", "siblings", nil)
+	merr, ok := err.(*ErrMultipleVclocks)
+	if !ok {
+		t.Fatalf("expected *ErrMultipleVclocks; got %v", err)
+	}
+	if len(merr.Vclocks) != 2 || merr.Vclocks[0] != "one" || merr.Vclocks[1] != "two" {
+		t.Errorf("unexpected vtags %q", merr.Vclocks)
+	}
+}
+
+func TestFetchSiblings(t *testing.T) {
+	var stored bytes.Buffer
+	var vclock string
+	srv := siblingServer(t, &stored, &vclock)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	sibs, err := c.FetchSiblings("testing", "siblings", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sibs) != 2 {
+		t.Fatalf("expected 2 siblings; got %d", len(sibs))
+	}
+	if sibs[0].Body.String() != "[1, 2]" || sibs[1].Body.String() != "[2,3]" {
+		t.Errorf("unexpected sibling bodies %q and %q", sibs[0].Body.String(), sibs[1].Body.String())
+	}
+	for _, s := range sibs {
+		if s.Vclock != "siblingclock" || s.Key != "siblings" {
+			t.Errorf("sibling missing vclock or key: %#v", s)
+		}
+	}
+	if LastModifiedWins(sibs) != sibs[1] {
+		t.Error("expected the second sibling to be the most recent")
+	}
+	if !sibs[1].LastModified().Equal(time.Date(2006, 1, 3, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected last modified time %s", sibs[1].LastModified())
+	}
+}
+
+func TestResolverWriteBack(t *testing.T) {
+	var stored bytes.Buffer
+	var vclock string
+	srv := siblingServer(t, &stored, &vclock)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient", WithResolver("testing", UnionJSONArrays))
+
+	// other buckets are unaffected
+	if _, err := c.Fetch("other", "siblings", nil); err == nil {
+		t.Error("expected an error from a bucket without a resolver")
+	}
+
+	o, err := c.Fetch("testing", "siblings", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Body.String() != "[1,2,3]" {
+		t.Errorf("expected body %q; got %q", "[1,2,3]", o.Body.String())
+	}
+	if vclock != "siblingclock" {
+		t.Errorf("expected resolved object to be written with vclock %q; got %q", "siblingclock", vclock)
+	}
+	if o.Vclock != "resolvedclock" {
+		t.Errorf("expected vclock %q after write-back; got %q", "resolvedclock", o.Vclock)
+	}
+}
+
+func TestResolverNil(t *testing.T) {
+	var stored bytes.Buffer
+	var vclock string
+	srv := siblingServer(t, &stored, &vclock)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient", WithResolver("testing", func([]*Object) *Object { return nil }))
+	_, err := c.Fetch("testing", "siblings", nil)
+	mv, ok := err.(*ErrMultipleVclocks)
+	if !ok || len(mv.Vclocks) != 2 || mv.Vclocks[0] != "one" {
+		t.Errorf("expected the siblings as an error; got %v", err)
+	}
+	if stored.Len() != 0 {
+		t.Error("nothing should have been stored")
+	}
+}
+
+func TestTypeResolver(t *testing.T) {
+	var stored bytes.Buffer
+	var vclock string
+	srv := siblingServer(t, &stored, &vclock)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient",
+		WithResolver("testing", LastModifiedWins),
+		WithTypeResolver("sets", "testing", UnionJSONArrays))
+
+	// the default type's resolver doesn't apply to other types
+	if _, err := c.BucketType("other").Fetch("testing", "siblings", nil); err == nil {
+		t.Error("expected an error from a bucket type without a resolver")
+	}
+	o, err := c.BucketType("sets").Fetch("testing", "siblings", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Body.String() != "[1,2,3]" {
+		t.Errorf("expected the bucket type's resolver to be used; got %q", o.Body.String())
+	}
+	if c.BucketType("default").resolver("testing") == nil {
+		t.Error("expected the \"default\" bucket type to use the default type's resolvers")
+	}
+}
@@ -0,0 +1,163 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how the client retries idempotent
+// operations (Fetch, GetUpdate, Delete, bucket listing,
+// IndexLookup, etc.) when riak responds with 503 or the
+// connection is reset, refused or times out. Retries are
+// spaced with jittered exponential backoff.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first; less than 2 disables retries
+	BaseDelay   time.Duration // delay before the first retry; defaults to 50ms
+	MaxDelay    time.Duration // upper bound on any one delay; defaults to 2s
+	RetryCreate bool          // also retry CreateObject, which may create duplicate objects
+
+	// RetryUpdates also retries counter increments and data
+	// type updates, which may be applied more than once
+	RetryUpdates bool
+}
+
+// WithRetry sets the client's retry policy. If riak still
+// responds with 503 after the last attempt, the operation
+// returns the same error it would without retries, e.g.
+// ErrTimeout, so that comparisons like err == ErrTimeout keep
+// working. If the last attempt fails to reach riak at all, a
+// *RetryError is returned; use errors.Is or errors.As with it.
+func WithRetry(p *RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+type noRetryKey struct{}
+
+// NoRetry returns a context that disables retries for any
+// operation performed with it, regardless of the client's
+// RetryPolicy.
+func NoRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// RetryError is returned when an operation has been
+// attempted more than once without success. Unwrap
+// returns the error from the last attempt.
+type RetryError struct {
+	Attempts []error // error from each attempt, in order
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("riak: gave up after %d attempts: %s", len(e.Attempts), e.Attempts[len(e.Attempts)-1])
+}
+
+func (e *RetryError) Unwrap() error { return e.Attempts[len(e.Attempts)-1] }
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// retryable returns whether or not a transport
+// error is worth trying again
+func retryable(err error) bool {
+	var nerr net.Error
+	switch {
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case strings.Contains(err.Error(), "connection reset"):
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the delay before retry number 'n' (starting at 1)
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = 50 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 2 * time.Second
+	}
+	d := base
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	// jitter between d/2 and d
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sendRetry sends a request, and if 'retry' is set, retries it
+// according to the client's policy. If the last attempt gets a
+// 503, its response is returned, so that callers turn it into
+// the same error as they would without retries.
+func (c *Client) sendRetry(req *http.Request, retry bool) (*http.Response, error) {
+	p := c.retry
+	ctx := req.Context()
+	if !retry || p == nil || p.MaxAttempts < 2 || ctx.Value(noRetryKey{}) != nil {
+		return c.sendOnce(req)
+	}
+	var errs []error
+	for n := 1; ; n++ {
+		r := req
+		if n > 1 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+		res, err := c.sendOnce(r)
+		switch {
+		case err == nil && res.StatusCode != 503:
+			return res, nil
+		case err == nil && n >= p.MaxAttempts:
+			return res, nil
+		case err == nil:
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			err = ErrTimeout
+		case err == ctx.Err() || !retryable(err):
+			if len(errs) == 0 {
+				return nil, err
+			}
+			return nil, &RetryError{Attempts: append(errs, err)}
+		}
+		errs = append(errs, err)
+		if n >= p.MaxAttempts {
+			return nil, &RetryError{Attempts: errs}
+		}
+
+		t := time.NewTimer(p.backoff(n))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, &RetryError{Attempts: append(errs, ctx.Err())}
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// returns 503 'fails' times before succeeding
+func flakyServer(fails int32, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(hits, 1) <= fails {
+			w.WriteHeader(503)
+			return
+		}
+		w.Header().Set("Location", r.URL.Path+"/newkey")
+		if r.Method == "POST" {
+			w.WriteHeader(201)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+}
+
+var testPolicy = &RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    5 * time.Millisecond,
+}
+
+func TestRetryFetch(t *testing.T) {
+	var hits int32
+	srv := flakyServer(2, &hits)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient", WithRetry(testPolicy))
+	o, err := c.Fetch("testing", "flaky", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Body.String() != "ok" {
+		t.Errorf("expected body %q; got %q", "ok", o.Body.String())
+	}
+	if hits != 3 {
+		t.Errorf("expected 3 attempts; got %d", hits)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	var hits int32
+	srv := flakyServer(5, &hits)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient", WithRetry(testPolicy))
+	// the same error as without retries
+	_, err := c.Fetch("testing", "flaky", nil)
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout; got %v", err)
+	}
+	if hits != 3 {
+		t.Errorf("expected 3 attempts; got %d", hits)
+	}
+
+	// connections that keep failing give a RetryError
+	hits = 0
+	hangup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer hangup.Close()
+	c = NewClient(hangup.URL, "testClient", WithRetry(testPolicy))
+	_, err = c.Fetch("testing", "flaky", nil)
+	var rerr *RetryError
+	if !errors.As(err, &rerr) || len(rerr.Attempts) != 3 {
+		t.Errorf("expected a RetryError after 3 attempts; got %v", err)
+	}
+}
+
+func TestRetryOptOut(t *testing.T) {
+	var hits int32
+	srv := flakyServer(2, &hits)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient", WithRetry(testPolicy))
+	_, err := c.FetchContext(NoRetry(context.Background()), "testing", "flaky", nil)
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout; got %v", err)
+	}
+
+	// CreateObject is not retried by default
+	hits = 0
+	obj := &Object{Bucket: "testing", Body: bytes.NewBufferString("body")}
+	err = c.CreateObject(obj, nil)
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout; got %v", err)
+	}
+	if hits != 1 {
+		t.Errorf("expected 1 attempt; got %d", hits)
+	}
+
+	// ... unless the policy says so
+	hits = 0
+	p := *testPolicy
+	p.RetryCreate = true
+	c = NewClient(srv.URL, "testClient", WithRetry(&p))
+	err = c.CreateObject(obj, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Key != "newkey" {
+		t.Errorf("expected key %q; got %q", "newkey", obj.Key)
+	}
+
+	// counter increments have their own flag
+	hits = 0
+	if _, err := c.IncrementCounter("testing", "ctr", 1, nil); err != ErrTimeout || hits != 1 {
+		t.Errorf("expected ErrTimeout after 1 attempt; got %v after %d", err, hits)
+	}
+	hits = 0
+	p.RetryUpdates = true
+	c.IncrementCounter("testing", "ctr", 1, nil)
+	if hits != 3 {
+		t.Errorf("expected 3 attempts; got %d", hits)
+	}
+}
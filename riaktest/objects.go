@@ -0,0 +1,300 @@
+package riaktest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// object is the value of a key; it has more than
+// one sibling when writes have conflicted
+type object struct {
+	vclock string
+	sibs   []*content
+}
+
+// content is one sibling
+type content struct {
+	ctype   string
+	body    []byte
+	vtag    string
+	lastmod time.Time
+	links   []link
+	meta    map[string]string   // by canonical header suffix
+	index   map[string][]string // by lowercase index name
+}
+
+type link struct {
+	bucket string
+	key    string
+	tag    string
+}
+
+// </riak/bucket/key>; riaktag="tag" or
+// </buckets/bucket/keys/key>; riaktag="tag"
+var linkrgx = regexp.MustCompile(`<([^>]*)>;\s*riaktag="([^"]*)"`)
+
+// the content described by a request
+func newContent(hdr http.Header, body []byte) *content {
+	c := &content{
+		ctype:   hdr.Get("Content-Type"),
+		body:    body,
+		lastmod: time.Now().UTC(),
+	}
+	if c.ctype == "" {
+		c.ctype = "application/octet-stream"
+	}
+	for _, val := range hdr["Link"] {
+		for _, m := range linkrgx.FindAllStringSubmatch(val, -1) {
+			seg := strings.Split(strings.Trim(m[1], "/"), "/")
+			var l link
+			switch {
+			case len(seg) == 3 && seg[0] == "riak":
+				l.bucket, l.key = seg[1], seg[2]
+			case len(seg) >= 4 && seg[len(seg)-2] == "keys":
+				l.bucket, l.key = seg[len(seg)-3], seg[len(seg)-1]
+			default:
+				continue
+			}
+			l.tag = m[2]
+			c.links = append(c.links, l)
+		}
+	}
+	for k, vals := range hdr {
+		switch {
+		case strings.HasPrefix(k, "X-Riak-Meta-"):
+			if c.meta == nil {
+				c.meta = make(map[string]string)
+			}
+			c.meta[strings.TrimPrefix(k, "X-Riak-Meta-")] = vals[0]
+		case strings.HasPrefix(k, "X-Riak-Index-"):
+			if c.index == nil {
+				c.index = make(map[string][]string)
+			}
+			name := strings.ToLower(strings.TrimPrefix(k, "X-Riak-Index-"))
+			for _, v := range vals {
+				for _, term := range strings.Split(v, ",") {
+					c.index[name] = append(c.index[name], strings.TrimSpace(term))
+				}
+			}
+		}
+	}
+	return c
+}
+
+// write the headers that describe 'c'
+func (c *content) header(hdr textproto.MIMEHeader) {
+	hdr.Set("Content-Type", c.ctype)
+	hdr.Set("Etag", `"`+c.vtag+`"`)
+	hdr.Set("Last-Modified", c.lastmod.Format(http.TimeFormat))
+	if len(c.links) > 0 {
+		links := make([]string, len(c.links))
+		for i, l := range c.links {
+			links[i] = "</riak/" + l.bucket + "/" + l.key + ">; riaktag=\"" + l.tag + "\""
+		}
+		hdr.Set("Link", strings.Join(links, ", "))
+	}
+	for k, v := range c.meta {
+		hdr.Set("X-Riak-Meta-"+k, v)
+	}
+	for k, v := range c.index {
+		hdr.Set("X-Riak-Index-"+k, strings.Join(v, ", "))
+	}
+}
+
+// a new unique id for vtags and keys; the multiplier
+// makes consecutive ids look unrelated
+func (s *Server) tick() string {
+	s.clock++
+	return strconv.FormatUint(s.clock*0x9e3779b97f4a7c15, 36)
+}
+
+// riak's vclocks are opaque base64
+func (s *Server) vclock() string {
+	return base64.StdEncoding.EncodeToString([]byte(s.tick()))
+}
+
+func unquote(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+// GET, HEAD, PUT, POST or DELETE an object; 'key' is
+// empty when POSTing an object without a key
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, btype string, bucket string, key string) {
+	switch r.Method {
+	case "GET", "HEAD":
+		var obj *object
+		if b := s.bucket(btype, bucket, false); b != nil {
+			obj = b.objects[key]
+		}
+		if obj == nil {
+			w.WriteHeader(404)
+			return
+		}
+		if len(obj.sibs) == 1 {
+			if m := r.Header.Get("If-None-Match"); m != "" && unquote(m) == obj.sibs[0].vtag {
+				w.WriteHeader(304)
+				return
+			}
+		}
+		writeObject(w, r, obj, 200)
+	case "PUT", "POST":
+		s.put(w, r, btype, bucket, key)
+	case "DELETE":
+		b := s.bucket(btype, bucket, false)
+		if b == nil || b.objects[key] == nil {
+			w.WriteHeader(404)
+			return
+		}
+		delete(b.objects, key)
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, btype string, bucket string, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	b := s.bucket(btype, bucket, true)
+	created := key == ""
+	if created {
+		key = s.tick()
+	}
+	obj := b.objects[key]
+
+	// conditional requests compare against
+	// the vtag of a single value
+	var vtag string
+	if obj != nil && len(obj.sibs) == 1 {
+		vtag = obj.sibs[0].vtag
+	}
+	if m := r.Header.Get("If-Match"); m != "" && (vtag == "" || unquote(m) != vtag) {
+		w.WriteHeader(412)
+		return
+	}
+	if m := r.Header.Get("If-None-Match"); m != "" && obj != nil && (m == "*" || unquote(m) == vtag) {
+		w.WriteHeader(412)
+		return
+	}
+
+	c := newContent(r.Header, body)
+	c.vtag = s.tick()
+	switch {
+	case obj == nil:
+		obj = &object{sibs: []*content{c}}
+		b.objects[key] = obj
+	case r.Header.Get("X-Riak-Vclock") == obj.vclock:
+		// descends from every sibling
+		obj.sibs = []*content{c}
+	case s.flag(btype, bucket, "allow_mult") && !s.flag(btype, bucket, "last_write_wins"):
+		obj.sibs = append(obj.sibs, c)
+	default:
+		obj.sibs = []*content{c}
+	}
+	obj.vclock = s.vclock()
+
+	code := 200
+	if created {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		w.Header().Set("Location", path+"/"+key)
+		code = 201
+	}
+	if r.URL.Query().Get("returnbody") == "true" {
+		writeObject(w, r, obj, code)
+		return
+	}
+	if !created {
+		code = 204
+	}
+	w.WriteHeader(code)
+}
+
+// write an object, or its siblings with 300 Multiple Choices
+func writeObject(w http.ResponseWriter, r *http.Request, obj *object, code int) {
+	w.Header().Set("X-Riak-Vclock", obj.vclock)
+	c := obj.sibs[0]
+	if vtag := r.URL.Query().Get("vtag"); vtag != "" {
+		c = nil
+		for _, sib := range obj.sibs {
+			if sib.vtag == vtag {
+				c = sib
+			}
+		}
+		if c == nil {
+			w.WriteHeader(404)
+			return
+		}
+	} else if len(obj.sibs) > 1 {
+		writeSiblings(w, r, obj)
+		return
+	}
+	c.header(textproto.MIMEHeader(w.Header()))
+	w.WriteHeader(code)
+	w.Write(c.body)
+}
+
+func writeSiblings(w http.ResponseWriter, r *http.Request, obj *object) {
+	if !strings.Contains(r.Header.Get("Accept"), "multipart/mixed") {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(300)
+		io.WriteString(w, "Siblings:\n")
+		for _, c := range obj.sibs {
+			io.WriteString(w, c.vtag+"\n")
+		}
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	mpw := multipart.NewWriter(buf)
+	for _, c := range obj.sibs {
+		hdr := make(textproto.MIMEHeader)
+		c.header(hdr)
+		part, _ := mpw.CreatePart(hdr)
+		part.Write(c.body)
+	}
+	mpw.Close()
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mpw.Boundary())
+	w.WriteHeader(300)
+	w.Write(buf.Bytes())
+}
+
+// GET /buckets
+func (s *Server) serveBuckets(w http.ResponseWriter, r *http.Request, btype string) {
+	var names []string
+	if t := s.btype(btype, false); t != nil {
+		for name, b := range t.buckets {
+			if len(b.objects) > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	if names == nil {
+		names = []string{}
+	}
+	writeJSON(w, 200, map[string][]string{"buckets": names})
+}
+
+// GET /buckets/bucket/keys
+func (s *Server) serveKeys(w http.ResponseWriter, r *http.Request, btype string, bucket string) {
+	keys := []string{}
+	if b := s.bucket(btype, bucket, false); b != nil {
+		for k := range b.objects {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	// keys=stream gets a single chunk
+	writeJSON(w, 200, map[string][]string{"keys": keys})
+}
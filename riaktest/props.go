@@ -0,0 +1,97 @@
+package riaktest
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// riak's defaults for the default bucket type
+const defaultProps = `{"n_val":3,"allow_mult":false,"last_write_wins":false,
+"precommit":[],"postcommit":[],
+"chash_keyfun":{"mod":"riak_core_util","fun":"chash_std_keyfun"},
+"linkfun":{"mod":"riak_kv_wm_link_walker","fun":"mapreduce_linkfun"},
+"old_vclock":86400,"young_vclock":20,"big_vclock":50,"small_vclock":50,
+"pr":0,"r":"quorum","w":"quorum","pw":0,"dw":"quorum","rw":"quorum",
+"basic_quorum":false,"notfound_ok":true,"dvv_enabled":false}`
+
+// the properties of 'btype', or of its bucket 'bucket'
+func (s *Server) props(btype string, bucket string) map[string]json.RawMessage {
+	props := make(map[string]json.RawMessage)
+	json.Unmarshal([]byte(defaultProps), &props)
+	if btype != "" {
+		// riak's defaults for new bucket types
+		props["allow_mult"] = json.RawMessage("true")
+		props["dvv_enabled"] = json.RawMessage("true")
+	}
+	if t := s.btype(btype, false); t != nil {
+		for k, v := range t.props {
+			props[k] = v
+		}
+		if b := t.buckets[bucket]; b != nil {
+			for k, v := range b.props {
+				props[k] = v
+			}
+		}
+	}
+	if bucket != "" {
+		props["name"], _ = json.Marshal(bucket)
+	}
+	return props
+}
+
+// whether 'prop' is true for the bucket
+func (s *Server) flag(btype string, bucket string, prop string) bool {
+	return string(s.props(btype, bucket)[prop]) == "true"
+}
+
+// GET, PUT or DELETE /buckets/bucket/props
+func (s *Server) serveProps(w http.ResponseWriter, r *http.Request, btype string, bucket string) {
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, 200, map[string]interface{}{"props": s.props(btype, bucket)})
+	case "PUT", "POST":
+		updateProps(w, r, s.bucket(btype, bucket, true).props)
+	case "DELETE":
+		if b := s.bucket(btype, bucket, false); b != nil {
+			b.props = make(map[string]json.RawMessage)
+		}
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+// GET or PUT /types/type/props
+func (s *Server) serveTypeProps(w http.ResponseWriter, r *http.Request, btype string) {
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, 200, map[string]interface{}{"props": s.props(btype, "")})
+	case "PUT", "POST":
+		if btype == "" {
+			// the default type's properties can't be changed
+			w.WriteHeader(405)
+			return
+		}
+		updateProps(w, r, s.btype(btype, true).props)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+// merge {"props":{...}} into 'props'
+func updateProps(w http.ResponseWriter, r *http.Request, props map[string]json.RawMessage) {
+	var body struct {
+		Props map[string]json.RawMessage `json:"props"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Props == nil {
+		w.WriteHeader(400)
+		return
+	}
+	for k, v := range body.Props {
+		if k == "name" {
+			continue
+		}
+		props[k] = v
+	}
+	w.WriteHeader(204)
+}
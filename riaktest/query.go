@@ -0,0 +1,199 @@
+package riaktest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GET /riak/bucket/key/bucket,tag,keep/...
+func (s *Server) serveWalk(w http.ResponseWriter, r *http.Request, bucket string, key string, steps []string) {
+	if r.Method != "GET" {
+		w.WriteHeader(405)
+		return
+	}
+	get := func(l link) *object {
+		if b := s.bucket("", l.bucket, false); b != nil {
+			return b.objects[l.key]
+		}
+		return nil
+	}
+	cur := []link{{bucket: bucket, key: key}}
+	if get(cur[0]) == nil {
+		w.WriteHeader(404)
+		return
+	}
+	var kept [][]link
+	for i, step := range steps {
+		spec := strings.Split(step, ",")
+		if len(spec) != 3 {
+			w.WriteHeader(400)
+			return
+		}
+		var next []link
+		seen := make(map[link]bool)
+		for _, from := range cur {
+			for _, c := range get(from).sibs {
+				for _, l := range c.links {
+					if (spec[0] != "_" && spec[0] != l.bucket) || (spec[1] != "_" && spec[1] != l.tag) {
+						continue
+					}
+					to := link{bucket: l.bucket, key: l.key}
+					if seen[to] || get(to) == nil {
+						continue
+					}
+					seen[to] = true
+					next = append(next, to)
+				}
+			}
+		}
+		// '_' keeps only the last step
+		if spec[2] == "1" || (spec[2] == "_" && i == len(steps)-1) {
+			kept = append(kept, next)
+		}
+		cur = next
+	}
+
+	// multipart/mixed of multipart/mixed
+	buf := bytes.NewBuffer(nil)
+	outer := multipart.NewWriter(buf)
+	for _, group := range kept {
+		inner := bytes.NewBuffer(nil)
+		mpw := multipart.NewWriter(inner)
+		for _, l := range group {
+			obj := get(l)
+			hdr := make(textproto.MIMEHeader)
+			obj.sibs[0].header(hdr)
+			hdr.Set("Location", "/riak/"+l.bucket+"/"+l.key)
+			hdr.Set("X-Riak-Vclock", obj.vclock)
+			part, _ := mpw.CreatePart(hdr)
+			part.Write(obj.sibs[0].body)
+		}
+		mpw.Close()
+		part, _ := outer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"multipart/mixed; boundary=" + mpw.Boundary()},
+		})
+		part.Write(inner.Bytes())
+	}
+	outer.Close()
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+outer.Boundary())
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
+
+// an index term and the key that it belongs to
+type match struct {
+	term string
+	key  string
+}
+
+// GET /buckets/bucket/index/index/value, or
+// /buckets/bucket/index/index/start/end
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request, btype string, bucket string, index string, args []string) {
+	if r.Method != "GET" {
+		w.WriteHeader(405)
+		return
+	}
+	index = strings.ToLower(index)
+	isInt := strings.HasSuffix(index, "_int")
+	less := func(a, b string) bool {
+		if isInt {
+			x, _ := strconv.ParseInt(a, 10, 64)
+			y, _ := strconv.ParseInt(b, 10, 64)
+			return x < y
+		}
+		return a < b
+	}
+	if isInt {
+		for _, a := range args {
+			if _, err := strconv.ParseInt(a, 10, 64); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+		}
+	}
+	q := r.URL.Query()
+	var rgx *regexp.Regexp
+	if expr := q.Get("term_regex"); expr != "" {
+		var err error
+		if rgx, err = regexp.Compile(expr); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+	}
+	inRange := func(term string) bool {
+		if len(args) == 1 {
+			return term == args[0]
+		}
+		return !less(term, args[0]) && !less(args[1], term) && (rgx == nil || rgx.MatchString(term))
+	}
+
+	var matches []match
+	if b := s.bucket(btype, bucket, false); b != nil {
+		for key, obj := range b.objects {
+			var terms []string
+			switch index {
+			case "$bucket":
+				terms = []string{bucket}
+			case "$key":
+				terms = []string{key}
+			default:
+				for _, c := range obj.sibs {
+					terms = append(terms, c.index[index]...)
+				}
+			}
+			seen := make(map[string]bool)
+			for _, t := range terms {
+				if !seen[t] && inRange(t) {
+					seen[t] = true
+					matches = append(matches, match{term: t, key: key})
+				}
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].term != matches[j].term {
+			return less(matches[i].term, matches[j].term)
+		}
+		return matches[i].key < matches[j].key
+	})
+
+	// continuations are the offset of the next page
+	off := 0
+	if cont := q.Get("continuation"); cont != "" {
+		b, err := base64.StdEncoding.DecodeString(cont)
+		if err == nil {
+			off, err = strconv.Atoi(string(b))
+		}
+		if err != nil || off < 0 || off > len(matches) {
+			w.WriteHeader(400)
+			return
+		}
+		matches = matches[off:]
+	}
+	out := make(map[string]interface{})
+	if max, _ := strconv.Atoi(q.Get("max_results")); max > 0 && max < len(matches) {
+		out["continuation"] = base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(off + max)))
+		matches = matches[:max]
+	}
+	if q.Get("return_terms") == "true" && len(args) == 2 {
+		results := make([]map[string]string, len(matches))
+		for i, m := range matches {
+			results[i] = map[string]string{m.term: m.key}
+		}
+		out["results"] = results
+	} else {
+		keys := make([]string, len(matches))
+		for i, m := range matches {
+			keys[i] = m.key
+		}
+		out["keys"] = keys
+	}
+	writeJSON(w, 200, out)
+}
@@ -0,0 +1,284 @@
+package riaktest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+)
+
+// Transport sends HTTP requests. *http.Client and
+// riak.Transport implementations are Transports.
+type Transport interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Mode is what a Recorder does with requests
+type Mode int
+
+const (
+	// Replay serves the responses in the cassette
+	Replay Mode = iota
+	// Record sends requests and records the responses
+	Record
+)
+
+// Recorder is a Transport that records requests and their
+// responses to a "cassette" file, or replays them from one.
+// Use it as a client's transport in order to capture traffic
+// from a real cluster and check it in as a test fixture:
+//
+//	mode := riaktest.Replay
+//	if *record {
+//		mode = riaktest.Record
+//	}
+//	rec, err := riaktest.NewRecorder("testdata/users.json", mode)
+//	...
+//	c := riak.NewClient("http://localhost:8098", "test", riak.WithTransport(rec))
+//	...
+//	err = rec.Save() // only writes the cassette when recording
+//
+// Requests are replayed by matching their method, path and query
+// (and the headers in MatchHeaders) against the recorded requests
+// that haven't been replayed yet, in the order they were recorded.
+type Recorder struct {
+	// MatchHeaders names request headers that also
+	// have to match, e.g. "If-None-Match"
+	MatchHeaders []string
+
+	mode Mode
+	file string
+	real Transport
+
+	mu   sync.Mutex
+	cas  cassette
+	used []bool
+}
+
+type cassette struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+type interaction struct {
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+type recordedRequest struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	body
+}
+
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	body
+}
+
+// bodies are kept as text when they can be, so
+// that cassettes are readable and diff well
+type body struct {
+	Body     string `json:"body,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // "base64", or empty for text
+}
+
+func newBody(b []byte) body {
+	if utf8.Valid(b) {
+		return body{Body: string(b)}
+	}
+	return body{Body: base64.StdEncoding.EncodeToString(b), Encoding: "base64"}
+}
+
+func (b body) bytes() ([]byte, error) {
+	if b.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(b.Body)
+	}
+	return []byte(b.Body), nil
+}
+
+// NewRecorder creates a recorder for the cassette 'file'. When
+// replaying, the cassette is read immediately. When recording,
+// requests are sent with http.DefaultClient unless Wrap is used,
+// and the cassette is only written by Save.
+func NewRecorder(file string, mode Mode) (*Recorder, error) {
+	r := &Recorder{mode: mode, file: file, real: http.DefaultClient}
+	if mode == Replay {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &r.cas); err != nil {
+			return nil, fmt.Errorf("riaktest: reading cassette %s: %s", file, err)
+		}
+		r.used = make([]bool, len(r.cas.Interactions))
+	}
+	return r, nil
+}
+
+// Wrap sets the transport that requests are sent with
+// while recording, and returns the recorder. It can be
+// used with riak.WrapTransport in order to record the
+// traffic of cluster or protocol buffers clients:
+//
+//	riak.WrapTransport(func(t riak.Transport) riak.Transport { return rec.Wrap(t) })
+func (r *Recorder) Wrap(t Transport) *Recorder {
+	r.real = t
+	return r
+}
+
+// Do records or replays a request
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	if r.mode == Record {
+		return r.record(req)
+	}
+	return r.replay(req)
+}
+
+// canonical query strings, so that the
+// order of the parameters doesn't matter
+func canonicalQuery(raw string) string {
+	v, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	return v.Encode()
+}
+
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	res, err := r.real.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	in := &interaction{
+		Request: recordedRequest{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Query:  canonicalQuery(req.URL.RawQuery),
+			Header: req.Header.Clone(),
+			body:   newBody(reqBody),
+		},
+		Response: recordedResponse{
+			Status: res.StatusCode,
+			Header: res.Header.Clone(),
+			body:   newBody(resBody),
+		},
+	}
+	r.mu.Lock()
+	r.cas.Interactions = append(r.cas.Interactions, in)
+	r.mu.Unlock()
+	return res, nil
+}
+
+func (r *Recorder) matches(rec *recordedRequest, req *http.Request) bool {
+	if rec.Method != req.Method || rec.Path != req.URL.Path || rec.Query != canonicalQuery(req.URL.RawQuery) {
+		return false
+	}
+	for _, h := range r.MatchHeaders {
+		if rec.Header.Get(h) != req.Header.Get(h) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	var in *interaction
+	for i, cand := range r.cas.Interactions {
+		if !r.used[i] && r.matches(&cand.Request, req) {
+			r.used[i] = true
+			in = cand
+			break
+		}
+	}
+	r.mu.Unlock()
+	if in == nil {
+		return nil, fmt.Errorf("riaktest: %s has no unused recording of %s %s", r.file, req.Method, req.URL.RequestURI())
+	}
+	b, err := in.Response.bytes()
+	if err != nil {
+		return nil, err
+	}
+	hdr := in.Response.Header.Clone()
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+		StatusCode:    in.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        hdr,
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
+
+// Unused returns the number of recorded requests that
+// haven't been replayed, e.g. in order to check that a
+// test made every request that it made when recorded.
+func (r *Recorder) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, u := range r.used {
+		if !u {
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the cassette if the recorder is recording,
+// creating its directory if necessary. It does nothing
+// when replaying.
+func (r *Recorder) Save() error {
+	if r.mode != Record {
+		return nil
+	}
+	r.mu.Lock()
+	b, err := json.MarshalIndent(&r.cas, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.file, append(b, '\n'), 0644)
+}
@@ -0,0 +1,96 @@
+package riaktest_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/philhofer/riak"
+	"github.com/philhofer/riak/riaktest"
+)
+
+// the requests made while recording and replaying
+func session(t *testing.T, c *riak.Client) {
+	o := &riak.Object{Bucket: "docs", Key: "a", Ctype: "application/octet-stream", Body: bytes.NewBuffer([]byte{0xff, 0x00, 0xfe})}
+	o.AddIndex("tag_bin", "red")
+	o.AddLink("next", "docs", "b")
+	if err := c.Store(o, map[string]string{"w": "2", "dw": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	store(t, c, "docs", "b", "second")
+	if up, err := c.GetUpdate(o, nil); err != nil || up {
+		t.Fatalf("GetUpdate: %v %v", up, err)
+	}
+	got, err := c.Fetch("docs", "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Body.Bytes(), []byte{0xff, 0x00, 0xfe}) || got.GetIndex("tag_bin") != "red" || got.Vclock != o.Vclock {
+		t.Errorf("bad object %+v", got)
+	}
+	kr, err := c.IndexLookup("docs", "tag_bin", "red")
+	if err != nil || len(kr.Keys) != 1 {
+		t.Errorf("index lookup: %v %v", kr, err)
+	}
+	// multipart responses
+	groups, err := c.WalkLinks(riak.NewLinkWalk("docs", "a").Step("docs", "next", true))
+	if err != nil || len(groups) != 1 || len(groups[0]) != 1 || groups[0][0].Body.String() != "second" {
+		t.Errorf("bad walk %v %v", groups, err)
+	}
+	if _, err := c.Fetch("docs", "missing", nil); err != riak.ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fixtures", "docs.json")
+
+	srv := riaktest.NewServer()
+	rec, err := riaktest.NewRecorder(file, riaktest.Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session(t, riak.NewClient(srv.URL, "test", riak.WithTransport(rec)))
+	srv.Close()
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"X-Riak-Vclock"`) || !strings.Contains(string(b), `"encoding": "base64"`) {
+		t.Errorf("cassette is missing headers or binary bodies:\n%s", b)
+	}
+
+	// the server is gone; every response is replayed
+	play, err := riaktest.NewRecorder(file, riaktest.Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play.MatchHeaders = []string{"If-None-Match"}
+	c := riak.NewClient("http://riak.invalid:8098", "test", riak.WithTransport(play))
+	session(t, c)
+	if n := play.Unused(); n != 0 {
+		t.Errorf("%d recorded requests weren't replayed", n)
+	}
+	if _, err := c.Fetch("docs", "a", nil); err == nil || !strings.Contains(err.Error(), "no unused recording of GET /riak/docs/a") {
+		t.Errorf("expected an error for a request that wasn't recorded; got %v", err)
+	}
+
+	// recording through another transport
+	srv = riaktest.NewServer()
+	defer srv.Close()
+	rec, _ = riaktest.NewRecorder(filepath.Join(t.TempDir(), "wrapped.json"), riaktest.Record)
+	c = riak.NewClient(srv.URL, "test", riak.WrapTransport(func(t riak.Transport) riak.Transport {
+		return rec.Wrap(t)
+	}))
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
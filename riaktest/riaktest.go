@@ -0,0 +1,248 @@
+// Package riaktest provides an in-memory fake of riak's HTTP
+// interface for tests. It implements objects (including vector
+// clocks, siblings and conditional requests), bucket and key
+// listing, secondary indexes, bucket and bucket type properties,
+// and link walking. It doesn't implement map/reduce, search,
+// counters or data types.
+//
+//	srv := riaktest.NewServer()
+//	defer srv.Close()
+//	c := riak.NewClient(srv.URL, "test")
+//
+// Faults can be injected in order to test error handling:
+//
+//	srv.Inject(riaktest.Fault{Path: "/riak/users", Status: 503, Times: 2})
+//
+// It also provides a Recorder, which records the traffic of a
+// client to a file and replays it later without a riak node.
+package riaktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake riak node
+type Server struct {
+	URL string // e.g. "http://127.0.0.1:49152"
+
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	types  map[string]*bucketType // by name; "" is the default type
+	faults []*Fault
+	clock  uint64 // for vclocks and vtags
+}
+
+type bucketType struct {
+	props   map[string]json.RawMessage // overrides
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	props   map[string]json.RawMessage // overrides
+	objects map[string]*object
+}
+
+// NewServer starts a fake riak node. Close it when done.
+func NewServer() *Server {
+	s := &Server{types: make(map[string]*bucketType)}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Reset deletes every object and clears every
+// bucket and bucket type property. Faults are
+// left alone.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.types = make(map[string]*bucketType)
+	s.mu.Unlock()
+}
+
+// Fault changes how the server responds to matching requests
+type Fault struct {
+	Method string        // matches any method if empty
+	Path   string        // prefix of the matching paths; matches any path if empty
+	Delay  time.Duration // before responding
+	Status int           // respond with this status instead of serving the request, if non-zero
+	Times  int           // the number of requests affected; every request if zero
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	return (f.Method == "" || f.Method == r.Method) && strings.HasPrefix(r.URL.Path, f.Path)
+}
+
+// Inject adds a fault. Faults apply in the order that
+// they were injected; only the first matching fault
+// affects a request.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	s.faults = append(s.faults, &f)
+	s.mu.Unlock()
+}
+
+// ClearFaults removes every fault
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	s.faults = nil
+	s.mu.Unlock()
+}
+
+// the first matching fault, if any
+func (s *Server) fault(r *http.Request) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if !f.matches(r) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// ServeHTTP serves riak's HTTP interface
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f := s.fault(r); f != nil {
+		if f.Delay > 0 {
+			t := time.NewTimer(f.Delay)
+			select {
+			case <-t.C:
+			case <-r.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+		if f.Status != 0 {
+			w.WriteHeader(f.Status)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if seg[0] == "" {
+		seg = seg[:0]
+	}
+	btype := ""
+	if len(seg) >= 2 && seg[0] == "types" {
+		btype = seg[1]
+		if btype == "default" {
+			btype = ""
+		}
+		seg = seg[2:]
+		switch {
+		case len(seg) == 1 && seg[0] == "props":
+			s.serveTypeProps(w, r, btype)
+			return
+		case len(seg) == 0 || seg[0] != "buckets":
+			w.WriteHeader(404)
+			return
+		}
+	}
+
+	switch {
+	case len(seg) == 0 && btype == "":
+		s.serveResources(w, r)
+	case len(seg) == 1 && seg[0] == "ping":
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("OK"))
+
+	// old-style /riak/... paths
+	case len(seg) == 2 && seg[0] == "riak":
+		if r.Method == "POST" {
+			s.serveObject(w, r, btype, seg[1], "")
+		} else {
+			s.serveProps(w, r, btype, seg[1])
+		}
+	case len(seg) == 3 && seg[0] == "riak":
+		s.serveObject(w, r, btype, seg[1], seg[2])
+	case len(seg) > 3 && seg[0] == "riak":
+		s.serveWalk(w, r, seg[1], seg[2], seg[3:])
+
+	// new-style /buckets/... paths
+	case len(seg) == 1 && seg[0] == "buckets":
+		s.serveBuckets(w, r, btype)
+	case len(seg) == 3 && seg[0] == "buckets" && seg[2] == "keys":
+		if r.Method == "POST" {
+			s.serveObject(w, r, btype, seg[1], "")
+		} else {
+			s.serveKeys(w, r, btype, seg[1])
+		}
+	case len(seg) == 4 && seg[0] == "buckets" && seg[2] == "keys":
+		s.serveObject(w, r, btype, seg[1], seg[3])
+	case len(seg) == 3 && seg[0] == "buckets" && seg[2] == "props":
+		s.serveProps(w, r, btype, seg[1])
+	case (len(seg) == 5 || len(seg) == 6) && seg[0] == "buckets" && seg[2] == "index":
+		s.serveIndex(w, r, btype, seg[1], seg[3], seg[4:])
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func (s *Server) serveResources(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]string{
+		"riak_kv_wm_buckets":     "/buckets",
+		"riak_kv_wm_bucket_type": "/types",
+		"riak_kv_wm_index":       "/buckets",
+		"riak_kv_wm_keylist":     "/buckets",
+		"riak_kv_wm_link_walker": "/riak",
+		"riak_kv_wm_ping":        "/ping",
+		"riak_kv_wm_props":       "/buckets",
+	})
+}
+
+// get a bucket type, creating it if 'create' is set
+func (s *Server) btype(name string, create bool) *bucketType {
+	t := s.types[name]
+	if t == nil && create {
+		t = &bucketType{
+			props:   make(map[string]json.RawMessage),
+			buckets: make(map[string]*bucket),
+		}
+		s.types[name] = t
+	}
+	return t
+}
+
+// get a bucket, creating it if 'create' is set
+func (s *Server) bucket(btype string, name string, create bool) *bucket {
+	t := s.btype(btype, create)
+	if t == nil {
+		return nil
+	}
+	b := t.buckets[name]
+	if b == nil && create {
+		b = &bucket{
+			props:   make(map[string]json.RawMessage),
+			objects: make(map[string]*object),
+		}
+		t.buckets[name] = b
+	}
+	return b
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,252 @@
+package riaktest_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/philhofer/riak"
+	"github.com/philhofer/riak/riaktest"
+)
+
+func store(t *testing.T, c *riak.Client, bucket string, key string, body string) *riak.Object {
+	o := &riak.Object{Bucket: bucket, Key: key, Ctype: "text/plain", Body: bytes.NewBufferString(body)}
+	if err := c.Store(o, nil); err != nil {
+		t.Fatalf("storing %s/%s: %s", bucket, key, err)
+	}
+	return o
+}
+
+func TestObjects(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := riak.NewClient(srv.URL, "test")
+
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch("users", "alice", nil); err != riak.ErrNotFound {
+		t.Fatalf("expected ErrNotFound; got %v", err)
+	}
+	o := store(t, c, "users", "alice", "hello")
+	o.Meta = map[string]string{"Owner": "bob"}
+	o.AddLink("friend", "users", "bob")
+	if err := c.Merge(o, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Fetch("users", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Body.String() != "hello" || got.Ctype != "text/plain" || got.Meta["Owner"] != "bob" || got.Vclock != o.Vclock {
+		t.Errorf("bad object %+v", got)
+	}
+	if key, bucket := got.GetLink("friend"); bucket != "users" || key != "bob" {
+		t.Errorf("bad link %s/%s", bucket, key)
+	}
+	if got.LastModified().IsZero() {
+		t.Error("no Last-Modified")
+	}
+
+	// stale etags fail
+	store(t, c, "users", "alice", "changed")
+	if err := c.Merge(o, nil); err != riak.ErrModified {
+		t.Errorf("expected ErrModified; got %v", err)
+	}
+	if up, err := c.GetUpdate(o, nil); err != nil || !up || o.Body.String() != "changed" {
+		t.Errorf("GetUpdate: %v %v %q", up, err, o.Body)
+	}
+	if up, err := c.GetUpdate(o, nil); err != nil || up {
+		t.Errorf("GetUpdate of a current object: %v %v", up, err)
+	}
+
+	created := &riak.Object{Bucket: "users", Body: bytes.NewBufferString("new")}
+	if err := c.CreateObject(created, nil); err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == "" || created.Vclock == "" {
+		t.Errorf("bad created object %+v", created)
+	}
+
+	keys, err := c.ListBucketKeys("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || (keys[0] != "alice" && keys[1] != "alice") {
+		t.Errorf("bad keys %v", keys)
+	}
+	buckets, err := c.GetBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0] != "users" {
+		t.Errorf("bad buckets %v", buckets)
+	}
+
+	if err := c.Delete(got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch("users", "alice", nil); err != riak.ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete; got %v", err)
+	}
+
+	// bucket types are separate namespaces
+	typed := c.BucketType("maps")
+	store(t, typed, "users", "carol", "typed")
+	if _, err := c.Fetch("users", "carol", nil); err != riak.ErrNotFound {
+		t.Errorf("typed object in the default type: %v", err)
+	}
+	if o, err := typed.Fetch("users", "carol", nil); err != nil || o.Body.String() != "typed" {
+		t.Errorf("typed fetch: %v", err)
+	}
+
+	srv.Reset()
+	if _, err := typed.Fetch("users", "carol", nil); err != riak.ErrNotFound {
+		t.Errorf("expected ErrNotFound after Reset; got %v", err)
+	}
+}
+
+func TestSiblings(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := riak.NewClient(srv.URL, "test")
+
+	// no siblings without allow_mult
+	store(t, c, "default", "k", "a")
+	store(t, c, "default", "k", "b")
+	if o, err := c.Fetch("default", "k", nil); err != nil || o.Body.String() != "b" {
+		t.Errorf("expected the last write to win: %v", err)
+	}
+
+	if err := c.SetBucketProps("sets", &riak.BucketProps{Mult: true}); err != nil {
+		t.Fatal(err)
+	}
+	store(t, c, "sets", "k", `["a"]`)
+	if err := c.Store(&riak.Object{Bucket: "sets", Key: "k", Body: bytes.NewBufferString(`["b"]`)}, nil); err == nil {
+		t.Error("expected siblings")
+	}
+	_, err := c.Fetch("sets", "k", nil)
+	mv, ok := err.(*riak.ErrMultipleVclocks)
+	if !ok || len(mv.Vclocks) != 2 {
+		t.Fatalf("expected 2 siblings; got %v", err)
+	}
+	o, err := c.Fetch("sets", "k", map[string]string{"vtag": mv.Vclocks[1]})
+	if err != nil || o.Body.String() != `["b"]` {
+		t.Errorf("fetching a sibling by vtag: %v", err)
+	}
+	sibs, err := c.FetchSiblings("sets", "k", nil)
+	if err != nil || len(sibs) != 2 {
+		t.Fatalf("FetchSiblings: %d %v", len(sibs), err)
+	}
+
+	// writing with the siblings' vclock resolves them
+	if _, err := c.FetchResolve("sets", "k", nil, riak.UnionJSONArrays); err != nil {
+		t.Fatal(err)
+	}
+	o, err = c.Fetch("sets", "k", nil)
+	if err != nil || o.Body.String() != `["a","b"]` {
+		t.Errorf("resolved %v %v", o, err)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := riak.NewClient(srv.URL, "test")
+
+	for i, name := range []string{"dave", "alice", "carol", "bob"} {
+		o := &riak.Object{Bucket: "users", Key: name, Body: bytes.NewBufferString(name)}
+		o.AddIndex("age_int", []string{"40", "9", "25", "31"}[i])
+		o.AddIndex("Name_bin", name)
+		if err := c.Store(o, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kr, err := c.IndexLookup("users", "name_bin", "carol")
+	if err != nil || len(kr.Keys) != 1 || kr.Keys[0] != "carol" {
+		t.Errorf("equality query: %v %v", kr, err)
+	}
+	// integer indexes compare numerically
+	kr, err = c.Query(riak.NewIndexQuery("users", "age_int").Range("10", "40").ReturnTerms())
+	if err != nil || len(kr.Results) != 3 || kr.Results[0] != (riak.IndexTerm{Term: "25", Key: "carol"}) {
+		t.Errorf("range query: %+v %v", kr, err)
+	}
+	var keys []string
+	it := c.QueryIter(riak.NewIndexQuery("users", riak.KeyIndex).Range("a", "z").MaxResults(3))
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil || len(keys) != 4 || keys[0] != "alice" || keys[3] != "dave" {
+		t.Errorf("paged $key query: %v %v", keys, it.Err())
+	}
+	kr, err = c.Query(riak.NewIndexQuery("users", riak.BucketIndex).Equal("users"))
+	if err != nil || len(kr.Keys) != 4 {
+		t.Errorf("$bucket query: %v %v", kr, err)
+	}
+}
+
+func TestLinkWalk(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := riak.NewClient(srv.URL, "test")
+
+	alice := &riak.Object{Bucket: "people", Key: "alice", Body: bytes.NewBufferString("alice")}
+	alice.AddLink("friend", "people", "bob")
+	alice.AddLink("pet", "pets", "rex")
+	bob := &riak.Object{Bucket: "people", Key: "bob", Body: bytes.NewBufferString("bob")}
+	bob.AddLink("friend", "people", "carol")
+	for _, o := range []*riak.Object{alice, bob} {
+		if err := c.Store(o, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store(t, c, "people", "carol", "carol")
+	store(t, c, "pets", "rex", "rex")
+
+	groups, err := c.WalkLinks(riak.NewLinkWalk("people", "alice").
+		Step("people", "friend", true).
+		Step("", "friend", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || len(groups[0]) != 1 || groups[0][0].Key != "bob" || len(groups[1]) != 1 || groups[1][0].Body.String() != "carol" {
+		t.Errorf("bad walk %v", groups)
+	}
+	pets, err := c.FollowMultiLink(alice, "pet")
+	if err != nil || len(pets) != 1 || pets[0].Bucket != "pets" || pets[0].Key != "rex" {
+		t.Errorf("FollowMultiLink: %v %v", pets, err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	srv := riaktest.NewServer()
+	defer srv.Close()
+	c := riak.NewClient(srv.URL, "test")
+	store(t, c, "users", "alice", "hello")
+
+	srv.Inject(riaktest.Fault{Path: "/riak/users", Status: 503, Times: 1})
+	if _, err := c.Fetch("users", "alice", nil); err != riak.ErrTimeout {
+		t.Errorf("expected ErrTimeout; got %v", err)
+	}
+	if _, err := c.Fetch("users", "alice", nil); err != nil {
+		t.Errorf("fault outlived its Times: %v", err)
+	}
+
+	retrying := riak.NewClient(srv.URL, "test", riak.WithRetry(&riak.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	srv.Inject(riaktest.Fault{Method: "GET", Status: 503, Times: 2})
+	if _, err := retrying.Fetch("users", "alice", nil); err != nil {
+		t.Errorf("expected a retry to succeed; got %v", err)
+	}
+
+	srv.Inject(riaktest.Fault{Path: "/riak/users/alice", Delay: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.FetchContext(ctx, "users", "alice", nil); err != context.DeadlineExceeded {
+		t.Errorf("expected a timeout; got %v", err)
+	}
+	srv.ClearFaults()
+	if _, err := c.Fetch("users", "alice", nil); err != nil {
+		t.Error(err)
+	}
+}
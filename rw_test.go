@@ -1,5 +1,3 @@
-// +build riak
-
 package riak
 
 import (
@@ -8,6 +6,10 @@ import (
 	"testing"
 )
 
+// the riak node that the read/write tests talk to; without
+// the riak build tag, TestMain points it at a riaktest fake
+var testHost = "http://localhost:8098"
+
 func dump(t *testing.T, c *Client, err error) {
 	t.Errorf("Error: %s", err)
 	body, _ := httputil.DumpRequest(c.lastreq(), false)
@@ -33,7 +35,7 @@ func TestMerge(t *testing.T) {
 	}
 
 	// write first body
-	c := newtestclient("http://localhost:8098")
+	c := newtestclient(testHost)
 	err := c.Store(objA, nil)
 	if err != nil {
 		dump(t, c, err)
@@ -95,7 +97,7 @@ func TestUpdate(t *testing.T) {
 	}
 
 	// Create an object
-	c := newtestclient("http://localhost:8098")
+	c := newtestclient(testHost)
 	err := c.Store(objA, nil)
 	if err != nil {
 		dump(t, c, err)
@@ -147,7 +149,7 @@ func TestCreateFetch(t *testing.T) {
 		Bucket: "testing",
 		Body:   &body,
 	}
-	c := newtestclient("http://localhost:8098")
+	c := newtestclient(testHost)
 	err := c.CreateObject(obj, nil)
 	if err != nil {
 		dump(t, c, err)
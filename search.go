@@ -0,0 +1,254 @@
+package riak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SearchQuery is a Riak Search (Solr) query.
+// Create one with NewSearchQuery.
+//
+//	q := NewSearchQuery("users", "name_s:Al*").
+//		Filter("age_i:[18 TO *]").
+//		Sort("age_i desc").
+//		Rows(20)
+type SearchQuery struct {
+	index string
+	q     string
+	fq    []string
+	sort  string
+	start int
+	rows  int
+	fl    []string
+	df    string
+}
+
+// NewSearchQuery creates a query of the search index
+// 'index' for 'q', which uses Solr query syntax.
+func NewSearchQuery(index string, q string) *SearchQuery {
+	return &SearchQuery{index: index, q: q}
+}
+
+// Filter adds a filter query (fq). Filters restrict
+// the results without affecting their scores. It may
+// be called many times.
+func (s *SearchQuery) Filter(fq string) *SearchQuery {
+	s.fq = append(s.fq, fq)
+	return s
+}
+
+// Sort orders the results, e.g. "score desc" or "name_s asc"
+func (s *SearchQuery) Sort(sort string) *SearchQuery {
+	s.sort = sort
+	return s
+}
+
+// Start skips the first 'n' results
+func (s *SearchQuery) Start(n int) *SearchQuery {
+	s.start = n
+	return s
+}
+
+// Rows sets the maximum number of documents returned.
+// Solr returns 10 by default.
+func (s *SearchQuery) Rows(n int) *SearchQuery {
+	s.rows = n
+	return s
+}
+
+// Fields limits the fields returned for each document (fl).
+// Include "_yz_rt", "_yz_rb" and "_yz_rk" in order to use
+// FetchSearchResults, and "score" for SearchDoc.Score.
+func (s *SearchQuery) Fields(fl ...string) *SearchQuery {
+	s.fl = fl
+	return s
+}
+
+// DefaultField sets the field that is searched
+// for terms in 'q' that don't name one (df)
+func (s *SearchQuery) DefaultField(df string) *SearchQuery {
+	s.df = df
+	return s
+}
+
+func (s *SearchQuery) query() url.Values {
+	v := make(url.Values)
+	v.Set("wt", "json")
+	v.Set("q", s.q)
+	for _, fq := range s.fq {
+		v.Add("fq", fq)
+	}
+	if s.sort != "" {
+		v.Set("sort", s.sort)
+	}
+	if s.start > 0 {
+		v.Set("start", strconv.Itoa(s.start))
+	}
+	if s.rows > 0 {
+		v.Set("rows", strconv.Itoa(s.rows))
+	}
+	if len(s.fl) > 0 {
+		v.Set("fl", strings.Join(s.fl, ","))
+	}
+	if s.df != "" {
+		v.Set("df", s.df)
+	}
+	return v
+}
+
+// SearchResult is the result of a search query
+type SearchResult struct {
+	NumFound int         // total number of matching documents
+	MaxScore float64     // only if the "score" field was returned
+	Docs     []SearchDoc // the documents in this page of results
+
+	raw json.RawMessage
+}
+
+// DecodeDocs unmarshals the documents into 'v',
+// which should be a pointer to a slice, e.g.
+//
+//	var users []struct {
+//		Name string `json:"name_s"`
+//		Age  int    `json:"age_i"`
+//	}
+//	err := res.DecodeDocs(&users)
+func (r *SearchResult) DecodeDocs(v interface{}) error {
+	if r.raw == nil {
+		return json.Unmarshal([]byte("[]"), v)
+	}
+	return json.Unmarshal(r.raw, v)
+}
+
+// SearchDoc is one document returned by a search
+type SearchDoc map[string]interface{}
+
+// Bucket returns the bucket of the object that
+// the document was indexed from (_yz_rb)
+func (d SearchDoc) Bucket() string { return d.str("_yz_rb") }
+
+// BucketType returns the bucket type of the object
+// that the document was indexed from (_yz_rt)
+func (d SearchDoc) BucketType() string { return d.str("_yz_rt") }
+
+// Key returns the key of the object that
+// the document was indexed from (_yz_rk)
+func (d SearchDoc) Key() string { return d.str("_yz_rk") }
+
+// Score returns the document's score, or zero
+// if the "score" field wasn't returned
+func (d SearchDoc) Score() float64 {
+	switch s := d["score"].(type) {
+	case float64:
+		return s
+	case string:
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+	return 0
+}
+
+func (d SearchDoc) str(field string) string {
+	s, _ := d[field].(string)
+	return s
+}
+
+// Search performs a search query. Search is
+// only supported over HTTP.
+func (c *Client) Search(q *SearchQuery) (*SearchResult, error) {
+	return c.SearchContext(context.Background(), q)
+}
+
+// SearchContext is like Search, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) SearchContext(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	if q.index == "" || q.q == "" {
+		return nil, errors.New("riak: search needs an index and a query")
+	}
+	res, err := c.do(ctx, "GET", "/search/query/"+q.index+"?"+q.query().Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		switch res.StatusCode {
+		case 400:
+			return nil, ErrBadRequest
+		case 404:
+			return nil, ErrNotFound
+		case 503:
+			return nil, ErrTimeout
+		default:
+			return nil, statusCode(res.StatusCode)
+		}
+	}
+	var body struct {
+		Response struct {
+			NumFound int             `json:"numFound"`
+			MaxScore float64         `json:"maxScore"`
+			Docs     json.RawMessage `json:"docs"`
+		} `json:"response"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	sr := &SearchResult{
+		NumFound: body.Response.NumFound,
+		MaxScore: body.Response.MaxScore,
+		raw:      body.Response.Docs,
+	}
+	if err := sr.DecodeDocs(&sr.Docs); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// FetchSearchResults fetches the object behind each document in
+// 'r', in order. Objects that have been deleted since they were
+// indexed are skipped. Valid options are the same as for Fetch.
+func (c *Client) FetchSearchResults(r *SearchResult, opts map[string]string) ([]*Object, error) {
+	return c.FetchSearchResultsContext(context.Background(), r, opts)
+}
+
+// FetchSearchResultsContext is like FetchSearchResults, but the
+// requests are abandoned if 'ctx' is done before they complete.
+func (c *Client) FetchSearchResultsContext(ctx context.Context, r *SearchResult, opts map[string]string) ([]*Object, error) {
+	objs := make([]*Object, 0, len(r.Docs))
+	for _, d := range r.Docs {
+		bucket, key := d.Bucket(), d.Key()
+		if bucket == "" || key == "" {
+			err := errors.New("riak: search document has no _yz_rb or _yz_rk field")
+			for _, o := range objs {
+				Release(o)
+			}
+			return nil, err
+		}
+		tc := c
+		switch bt := d.BucketType(); bt {
+		case "":
+			// _yz_rt wasn't returned
+		case "default":
+			tc = c.BucketType("")
+		default:
+			tc = c.BucketType(bt)
+		}
+		o, err := tc.FetchContext(ctx, bucket, key, opts)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			for _, o := range objs {
+				Release(o)
+			}
+			return nil, err
+		}
+		objs = append(objs, o)
+	}
+	return objs, nil
+}
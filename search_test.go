@@ -0,0 +1,94 @@
+package riak
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const solrBody = `{"responseHeader":{"status":0,"QTime":3},
+"response":{"numFound":3,"start":0,"maxScore":2.5,"docs":[
+{"_yz_rb":"users","_yz_rk":"alice","name_s":"Alice","age_i":30,"score":2.5},
+{"_yz_rb":"users","_yz_rk":"gone","name_s":"Al","age_i":40,"score":1.0}
+]}}`
+
+func TestSearchQuery(t *testing.T) {
+	q := NewSearchQuery("users", "name_s:Al*").
+		Filter("age_i:[18 TO *]").
+		Filter("active_b:true").
+		Sort("age_i desc").
+		Start(10).
+		Rows(2).
+		Fields("_yz_rb", "_yz_rk", "score").
+		DefaultField("name_s")
+	v := q.query()
+	if v.Get("wt") != "json" || v.Get("q") != "name_s:Al*" || v.Get("df") != "name_s" {
+		t.Errorf("bad query: %v", v)
+	}
+	if len(v["fq"]) != 2 || v.Get("sort") != "age_i desc" {
+		t.Errorf("bad filters or sort: %v", v)
+	}
+	if v.Get("start") != "10" || v.Get("rows") != "2" || v.Get("fl") != "_yz_rb,_yz_rk,score" {
+		t.Errorf("bad paging or fields: %v", v)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/search/query/users":
+			if r.URL.Query().Get("q") != "name_s:Al*" {
+				w.WriteHeader(400)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, solrBody)
+		case r.URL.Path == "/search/query/missing":
+			w.WriteHeader(404)
+		case r.URL.Path == "/riak/users/alice":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"name":"Alice"}`)
+		case strings.HasPrefix(r.URL.Path, "/riak/"):
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "testClient")
+	res, err := c.Search(NewSearchQuery("users", "name_s:Al*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NumFound != 3 || res.MaxScore != 2.5 || len(res.Docs) != 2 {
+		t.Fatalf("bad result: %+v", res)
+	}
+	if d := res.Docs[0]; d.Bucket() != "users" || d.Key() != "alice" || d.Score() != 2.5 {
+		t.Errorf("bad first doc: %v", d)
+	}
+
+	var users []struct {
+		Name string `json:"name_s"`
+		Age  int    `json:"age_i"`
+	}
+	if err := res.DecodeDocs(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1].Name != "Al" || users[1].Age != 40 {
+		t.Errorf("decoded %+v", users)
+	}
+
+	// "gone" was deleted after it was indexed
+	objs, err := c.FetchSearchResults(res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "alice" || objs[0].Body.String() != `{"name":"Alice"}` {
+		t.Errorf("fetched %v", objs)
+	}
+
+	if _, err := c.Search(NewSearchQuery("missing", "*:*")); err != ErrNotFound {
+		t.Errorf("missing index: expected ErrNotFound; got %v", err)
+	}
+}
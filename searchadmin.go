@@ -0,0 +1,191 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// SearchIndex describes a search index
+type SearchIndex struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema,omitempty"` // "_yz_default" if empty
+	Nval   int    `json:"n_val,omitempty"`  // riak's default if zero
+}
+
+// CreateSearchIndex creates a search index, or updates it if it
+// already exists. Riak creates indexes asynchronously, so an index
+// may not be usable for a few seconds after this returns.
+func (c *Client) CreateSearchIndex(idx SearchIndex) error {
+	return c.CreateSearchIndexContext(context.Background(), idx)
+}
+
+// CreateSearchIndexContext is like CreateSearchIndex, but the request
+// is abandoned if 'ctx' is done before it completes.
+func (c *Client) CreateSearchIndexContext(ctx context.Context, idx SearchIndex) error {
+	if idx.Name == "" {
+		return errors.New("riak: search index needs a name")
+	}
+	body, err := json.Marshal(struct {
+		Schema string `json:"schema,omitempty"`
+		Nval   int    `json:"n_val,omitempty"`
+	}{idx.Schema, idx.Nval})
+	if err != nil {
+		return err
+	}
+	req, err := c.newreq(ctx, "PUT", "/search/index/"+idx.Name, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	return noContent(res)
+}
+
+// GetSearchIndex gets the description of a search index
+func (c *Client) GetSearchIndex(name string) (*SearchIndex, error) {
+	return c.GetSearchIndexContext(context.Background(), name)
+}
+
+// GetSearchIndexContext is like GetSearchIndex, but the request
+// is abandoned if 'ctx' is done before it completes.
+func (c *Client) GetSearchIndexContext(ctx context.Context, name string) (*SearchIndex, error) {
+	idx := new(SearchIndex)
+	if err := c.getJSON(ctx, "/search/index/"+name, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// ListSearchIndexes gets the description of every search index
+func (c *Client) ListSearchIndexes() ([]SearchIndex, error) {
+	return c.ListSearchIndexesContext(context.Background())
+}
+
+// ListSearchIndexesContext is like ListSearchIndexes, but the
+// request is abandoned if 'ctx' is done before it completes.
+func (c *Client) ListSearchIndexesContext(ctx context.Context) ([]SearchIndex, error) {
+	var idxs []SearchIndex
+	if err := c.getJSON(ctx, "/search/index", &idxs); err != nil {
+		return nil, err
+	}
+	return idxs, nil
+}
+
+// DeleteSearchIndex deletes a search index. Riak refuses to
+// delete an index that is still associated with a bucket.
+func (c *Client) DeleteSearchIndex(name string) error {
+	return c.DeleteSearchIndexContext(context.Background(), name)
+}
+
+// DeleteSearchIndexContext is like DeleteSearchIndex, but the
+// request is abandoned if 'ctx' is done before it completes.
+func (c *Client) DeleteSearchIndexContext(ctx context.Context, name string) error {
+	res, err := c.do(ctx, "DELETE", "/search/index/"+name, nil)
+	if err != nil {
+		return err
+	}
+	return noContent(res)
+}
+
+// PutSearchSchema uploads a Solr schema (XML) named 'name'.
+// Indexes can then be created with that schema.
+func (c *Client) PutSearchSchema(name string, schema []byte) error {
+	return c.PutSearchSchemaContext(context.Background(), name, schema)
+}
+
+// PutSearchSchemaContext is like PutSearchSchema, but the request
+// is abandoned if 'ctx' is done before it completes.
+func (c *Client) PutSearchSchemaContext(ctx context.Context, name string, schema []byte) error {
+	req, err := c.newreq(ctx, "PUT", "/search/schema/"+name, bytes.NewReader(schema))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	res, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	return noContent(res)
+}
+
+// GetSearchSchema downloads the Solr schema (XML) named 'name'
+func (c *Client) GetSearchSchema(name string) ([]byte, error) {
+	return c.GetSearchSchemaContext(context.Background(), name)
+}
+
+// GetSearchSchemaContext is like GetSearchSchema, but the request
+// is abandoned if 'ctx' is done before it completes.
+func (c *Client) GetSearchSchemaContext(ctx context.Context, name string) ([]byte, error) {
+	res, err := c.do(ctx, "GET", "/search/schema/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := streamStatus(res); err != nil {
+		return nil, err
+	}
+	schema, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	return schema, err
+}
+
+// SetBucketSearchIndex associates a bucket with a search index
+// by setting its search_index property, leaving its other
+// properties alone. Objects stored in the bucket from then on
+// are indexed. An empty 'index' stops indexing the bucket.
+func (c *Client) SetBucketSearchIndex(bucket string, index string) error {
+	return c.SetBucketSearchIndexContext(context.Background(), bucket, index)
+}
+
+// SetBucketSearchIndexContext is like SetBucketSearchIndex, but
+// the request is abandoned if 'ctx' is done before it completes.
+func (c *Client) SetBucketSearchIndexContext(ctx context.Context, bucket string, index string) error {
+	if index == "" {
+		index = "_dont_index_"
+	}
+	body, err := json.Marshal(map[string]map[string]string{
+		"props": {"search_index": index},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := c.newreq(ctx, "PUT", bucketPath(c.btype, bucket)+"/props", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	return noContent(res)
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	res, err := c.do(ctx, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	if err := streamStatus(res); err != nil {
+		return err
+	}
+	err = json.NewDecoder(res.Body).Decode(v)
+	res.Body.Close()
+	return err
+}
+
+// for requests that succeed with 204 (or 200)
+// and whose response bodies don't matter
+func noContent(res *http.Response) error {
+	if res.StatusCode == 200 || res.StatusCode == 204 {
+		res.Body.Close()
+		return nil
+	}
+	return streamStatus(res)
+}
@@ -0,0 +1,165 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// an in-memory version of riak's search admin API
+type fakeSearchAdmin struct {
+	sync.Mutex
+	indexes map[string]SearchIndex
+	schemas map[string][]byte
+	props   map[string]map[string]interface{}
+}
+
+func (f *fakeSearchAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case r.URL.Path == "/search/index":
+		list := []SearchIndex{}
+		for _, idx := range f.indexes {
+			list = append(list, idx)
+		}
+		json.NewEncoder(w).Encode(list)
+
+	case strings.HasPrefix(r.URL.Path, "/search/index/"):
+		name := strings.TrimPrefix(r.URL.Path, "/search/index/")
+		switch r.Method {
+		case "PUT":
+			idx := SearchIndex{Name: name, Schema: "_yz_default", Nval: 3}
+			json.NewDecoder(r.Body).Decode(&idx)
+			if _, ok := f.schemas[idx.Schema]; !ok && idx.Schema != "_yz_default" {
+				w.WriteHeader(400)
+				return
+			}
+			f.indexes[name] = idx
+			w.WriteHeader(204)
+		case "GET":
+			idx, ok := f.indexes[name]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			json.NewEncoder(w).Encode(idx)
+		case "DELETE":
+			if _, ok := f.indexes[name]; !ok {
+				w.WriteHeader(404)
+				return
+			}
+			delete(f.indexes, name)
+			w.WriteHeader(204)
+		}
+
+	case strings.HasPrefix(r.URL.Path, "/search/schema/"):
+		name := strings.TrimPrefix(r.URL.Path, "/search/schema/")
+		switch r.Method {
+		case "PUT":
+			if r.Header.Get("Content-Type") != "application/xml" {
+				w.WriteHeader(415)
+				return
+			}
+			f.schemas[name], _ = io.ReadAll(r.Body)
+			w.WriteHeader(204)
+		case "GET":
+			s, ok := f.schemas[name]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write(s)
+		}
+
+	case strings.HasSuffix(r.URL.Path, "/props") && r.Method == "PUT":
+		bucket := strings.Split(r.URL.Path, "/")[2]
+		var body struct {
+			Props map[string]interface{} `json:"props"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if f.props[bucket] == nil {
+			f.props[bucket] = map[string]interface{}{"n_val": 3}
+		}
+		for k, v := range body.Props {
+			f.props[bucket][k] = v
+		}
+		w.WriteHeader(204)
+	}
+}
+
+func TestSearchAdmin(t *testing.T) {
+	f := &fakeSearchAdmin{
+		indexes: make(map[string]SearchIndex),
+		schemas: make(map[string][]byte),
+		props:   make(map[string]map[string]interface{}),
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	schema := []byte(`<?xml version="1.0"?><schema name="users" version="1.5"></schema>`)
+	if err := c.PutSearchSchema("users", schema); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetSearchSchema("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, schema) {
+		t.Errorf("got schema %q", got)
+	}
+	if _, err := c.GetSearchSchema("nope"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+
+	if err := c.CreateSearchIndex(SearchIndex{Name: "users_idx", Schema: "users", Nval: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateSearchIndex(SearchIndex{Name: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateSearchIndex(SearchIndex{Name: "bad", Schema: "nope"}); err != ErrBadRequest {
+		t.Errorf("expected ErrBadRequest; got %v", err)
+	}
+	idx, err := c.GetSearchIndex("users_idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *idx != (SearchIndex{Name: "users_idx", Schema: "users", Nval: 5}) {
+		t.Errorf("got index %+v", idx)
+	}
+	idxs, err := c.ListSearchIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idxs) != 2 {
+		t.Errorf("listed %+v", idxs)
+	}
+
+	if err := c.SetBucketSearchIndex("users", "users_idx"); err != nil {
+		t.Fatal(err)
+	}
+	if p := f.props["users"]; p["search_index"] != "users_idx" || p["n_val"] != 3 {
+		t.Errorf("bucket props are %v", p)
+	}
+	if err := c.SetBucketSearchIndex("users", ""); err != nil {
+		t.Fatal(err)
+	}
+	if p := f.props["users"]; p["search_index"] != "_dont_index_" {
+		t.Errorf("bucket props are %v", p)
+	}
+
+	if err := c.DeleteSearchIndex("plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSearchIndex("plain"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+}
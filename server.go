@@ -0,0 +1,242 @@
+package riak
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Ping checks that the node is up
+func (c *Client) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+// PingContext is like Ping, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) PingContext(ctx context.Context) error {
+	res, err := c.do(ctx, "GET", "/ping", nil)
+	if err != nil {
+		return err
+	}
+	return noContent(res)
+}
+
+// Latency is a latency distribution reported by riak
+type Latency struct {
+	Mean   time.Duration
+	Median time.Duration
+	P95    time.Duration
+	P99    time.Duration
+	P100   time.Duration // the maximum
+}
+
+// Stats are a node's statistics. Counts without the
+// "Total" suffix are over the last minute. Every stat
+// that doesn't have a field is kept in Extra.
+type Stats struct {
+	Node    string `json:"nodename"`
+	Version string `json:"riak_kv_version"`
+
+	NodeGets      int64   `json:"node_gets"`
+	NodePuts      int64   `json:"node_puts"`
+	NodeGetsTotal int64   `json:"node_gets_total"`
+	NodePutsTotal int64   `json:"node_puts_total"`
+	GetLatency    Latency `json:"-"` // node_get_fsm_time
+	PutLatency    Latency `json:"-"` // node_put_fsm_time
+
+	VnodeGets        int64 `json:"vnode_gets"`
+	VnodePuts        int64 `json:"vnode_puts"`
+	VnodeGetsTotal   int64 `json:"vnode_gets_total"`
+	VnodePutsTotal   int64 `json:"vnode_puts_total"`
+	VnodeIndexReads  int64 `json:"vnode_index_reads"`
+	VnodeIndexWrites int64 `json:"vnode_index_writes"`
+
+	RingMembers       []string `json:"ring_members"`
+	RingNumPartitions int      `json:"ring_num_partitions"`
+	RingOwnership     string   `json:"ring_ownership"`
+	ConnectedNodes    []string `json:"connected_nodes"`
+
+	// bytes
+	MemoryTotal     int64 `json:"memory_total"`
+	MemoryProcesses int64 `json:"memory_processes"`
+	MemorySystem    int64 `json:"memory_system"`
+	MemoryBinary    int64 `json:"memory_binary"`
+	MemoryEts       int64 `json:"memory_ets"`
+
+	Extra map[string]json.RawMessage `json:"-"` // unknown stats
+}
+
+type rawStats Stats
+
+var latencyStats = map[string]func(*Stats) *Latency{
+	"node_get_fsm_time": func(s *Stats) *Latency { return &s.GetLatency },
+	"node_put_fsm_time": func(s *Stats) *Latency { return &s.PutLatency },
+}
+
+// the names of the stats that have fields
+var knownStats = make(map[string]bool)
+
+func init() {
+	b, _ := json.Marshal(new(rawStats))
+	var all map[string]json.RawMessage
+	json.Unmarshal(b, &all)
+	for name := range all {
+		knownStats[name] = true
+	}
+	for name := range latencyStats {
+		for _, sfx := range []string{"_mean", "_median", "_95", "_99", "_100"} {
+			knownStats[name+sfx] = true
+		}
+	}
+}
+
+// UnmarshalJSON sets the known stats,
+// and keeps the rest in Extra
+func (s *Stats) UnmarshalJSON(b []byte) error {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, (*rawStats)(s)); err != nil {
+		return err
+	}
+	for name, lat := range latencyStats {
+		l := lat(s)
+		l.Mean = micros(all[name+"_mean"])
+		l.Median = micros(all[name+"_median"])
+		l.P95 = micros(all[name+"_95"])
+		l.P99 = micros(all[name+"_99"])
+		l.P100 = micros(all[name+"_100"])
+	}
+	s.Extra = nil
+	for name, v := range all {
+		if knownStats[name] {
+			continue
+		}
+		if s.Extra == nil {
+			s.Extra = make(map[string]json.RawMessage)
+		}
+		s.Extra[name] = v
+	}
+	return nil
+}
+
+// riak reports latencies in microseconds; stats
+// without any samples may be "undefined"
+func micros(v json.RawMessage) time.Duration {
+	var f float64
+	if json.Unmarshal(v, &f) != nil {
+		return 0
+	}
+	return time.Duration(f * float64(time.Microsecond))
+}
+
+// Stats gets the statistics of the node
+// that serves the request. Stats is only
+// supported over HTTP.
+func (c *Client) Stats() (*Stats, error) {
+	return c.StatsContext(context.Background())
+}
+
+// StatsContext is like Stats, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) StatsContext(ctx context.Context) (*Stats, error) {
+	s := new(Stats)
+	if err := c.getJSON(ctx, "/stats", s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Resources maps the names of riak's HTTP
+// resources (e.g. "riak_kv_wm_buckets") to
+// the paths that the node serves them at.
+type Resources map[string]string
+
+// the prefixes that the client uses, and the
+// resources that riak serves them as
+var resourcePrefixes = []struct {
+	prefix string
+	names  []string // in order of preference
+}{
+	{"/riak", []string{"riak_kv_wm_link_walker", "riak_kv_wm_raw"}},
+	{"/buckets", []string{"riak_kv_wm_buckets"}},
+	{"/types", []string{"riak_kv_wm_bucket_type"}},
+	{"/mapred", []string{"riak_kv_wm_mapred"}},
+	{"/ping", []string{"riak_kv_wm_ping"}},
+	{"/stats", []string{"riak_kv_wm_stats"}},
+}
+
+// Resources lists the node's HTTP resources. Pass
+// them to UseResources in order to talk to nodes
+// that serve them at non-default paths. Resources
+// is only supported over HTTP.
+func (c *Client) Resources() (Resources, error) {
+	return c.ResourcesContext(context.Background())
+}
+
+// ResourcesContext is like Resources, but the request
+// is abandoned if 'ctx' is done before it completes.
+func (c *Client) ResourcesContext(ctx context.Context) (Resources, error) {
+	req, err := c.newreq(ctx, "GET", "/", nil)
+	if err != nil {
+		return nil, err
+	}
+	// otherwise riak responds with an HTML list of links
+	req.Header.Set("Accept", "application/json")
+	res, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := streamStatus(res); err != nil {
+		return nil, err
+	}
+	var r Resources
+	err = json.NewDecoder(res.Body).Decode(&r)
+	res.Body.Close()
+	return r, err
+}
+
+// UseResources returns a client that sends requests to the
+// paths in 'r' instead of the default ones (/riak, /buckets,
+// /types, /mapred, /ping and /stats). Resources that aren't
+// in 'r' keep their default paths. It shares the connections
+// and options of 'c'.
+//
+//	r, err := c.Resources()
+//	if err != nil {
+//		return err
+//	}
+//	c = c.UseResources(r)
+func (c *Client) UseResources(r Resources) *Client {
+	rc := *c
+	rc.prefixes = make(map[string]string)
+	for _, rp := range resourcePrefixes {
+		for _, name := range rp.names {
+			if p := strings.TrimSuffix(r[name], "/"); p != "" {
+				if p != rp.prefix {
+					rc.prefixes[rp.prefix] = p
+				}
+				break
+			}
+		}
+	}
+	return &rc
+}
+
+// rewrite the default prefix of 'path', if
+// the client uses a different one
+func (c *Client) rewrite(path string) string {
+	if len(c.prefixes) == 0 || len(path) < 2 {
+		return path
+	}
+	end := strings.IndexAny(path[1:], "/?") + 1
+	if end == 0 {
+		end = len(path)
+	}
+	if p, ok := c.prefixes[path[:end]]; ok {
+		return p + path[end:]
+	}
+	return path
+}
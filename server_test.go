@@ -0,0 +1,113 @@
+package riak
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testStats = `{"nodename":"riak@10.0.0.1","riak_kv_version":"2.2.3",
+"node_gets":120,"node_puts":45,"node_gets_total":98765,"node_puts_total":4321,
+"node_get_fsm_time_mean":1250.5,"node_get_fsm_time_median":900,"node_get_fsm_time_95":3000,
+"node_get_fsm_time_99":8000,"node_get_fsm_time_100":20000,
+"node_put_fsm_time_mean":"undefined","node_put_fsm_time_median":0,
+"vnode_gets":360,"vnode_puts":135,"vnode_index_reads":7,
+"ring_members":["riak@10.0.0.1","riak@10.0.0.2"],"ring_num_partitions":64,
+"connected_nodes":["riak@10.0.0.2"],"memory_total":104857600,"memory_processes":52428800,
+"pbc_active":3,"storage_backend":"riak_kv_eleveldb_backend"}`
+
+func TestServer(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/ping", "/kv/ping":
+			io.WriteString(w, "OK")
+		case "/stats":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, testStats)
+		case "/":
+			if r.Header.Get("Accept") != "application/json" {
+				w.WriteHeader(406)
+				return
+			}
+			io.WriteString(w, `{"riak_kv_wm_buckets":"/kv/buckets","riak_kv_wm_link_walker":"/kv/riak",
+"riak_kv_wm_ping":"/kv/ping","riak_kv_wm_stats":"/stats","riak_kv_wm_mapred":"/mapred"}`)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Node != "riak@10.0.0.1" || s.Version != "2.2.3" || s.NodeGets != 120 || s.NodePutsTotal != 4321 {
+		t.Errorf("bad node stats %+v", s)
+	}
+	if s.VnodeGets != 360 || s.VnodeIndexReads != 7 || s.RingNumPartitions != 64 || s.MemoryTotal != 104857600 {
+		t.Errorf("bad vnode, ring or memory stats %+v", s)
+	}
+	if len(s.RingMembers) != 2 || len(s.ConnectedNodes) != 1 || s.ConnectedNodes[0] != "riak@10.0.0.2" {
+		t.Errorf("bad nodes %v %v", s.RingMembers, s.ConnectedNodes)
+	}
+	want := Latency{
+		Mean:   1250500 * time.Nanosecond,
+		Median: 900 * time.Microsecond,
+		P95:    3 * time.Millisecond,
+		P99:    8 * time.Millisecond,
+		P100:   20 * time.Millisecond,
+	}
+	if s.GetLatency != want || s.PutLatency != (Latency{}) {
+		t.Errorf("bad latencies %+v %+v", s.GetLatency, s.PutLatency)
+	}
+	if len(s.Extra) != 2 || string(s.Extra["pbc_active"]) != "3" || s.Extra["node_gets"] != nil {
+		t.Errorf("bad extra stats %v", s.Extra)
+	}
+
+	r, err := c.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r["riak_kv_wm_buckets"] != "/kv/buckets" {
+		t.Errorf("bad resources %v", r)
+	}
+	kv := c.UseResources(r)
+	paths = nil
+	kv.Ping()
+	kv.Fetch("b", "k", nil)
+	kv.ListBucketKeys("b")
+	kv.Stats()
+	kv.BucketType("t").GetBucketProps("b")
+	c.Ping() // unchanged
+	want2 := []string{"/kv/ping", "/kv/riak/b/k", "/kv/buckets/b/keys", "/stats", "/types/t/buckets/b/props", "/ping"}
+	if len(paths) != len(want2) {
+		t.Fatalf("expected requests %q; got %q", want2, paths)
+	}
+	for i := range want2 {
+		if paths[i] != want2[i] {
+			t.Errorf("request %d: expected %q; got %q", i, want2[i], paths[i])
+		}
+	}
+}
+
+func TestPBPing(t *testing.T) {
+	srv := newFakePB(t)
+	defer srv.Close()
+	c := NewClient("", "testClient", Protobuf(srv.addr(), 1))
+	defer c.Close()
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Stats(); err != errPBUnsupported {
+		t.Errorf("expected %v; got %v", errPBUnsupported, err)
+	}
+}
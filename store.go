@@ -1,7 +1,7 @@
 package riak
 
 import (
-	"net/http"
+	"context"
 	"net/url"
 	"strings"
 )
@@ -13,8 +13,21 @@ import (
 // - 'dw' - durable write quorum (number, 'quorum', or 'all')
 // - 'pw' - primary replicas (number, 'quorum', or 'all')
 func (c *Client) CreateObject(o *Object, opts map[string]string) error {
+	return c.CreateObjectContext(context.Background(), o, opts)
+}
+
+// CreateObjectContext is like CreateObject, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) CreateObjectContext(ctx context.Context, o *Object, opts map[string]string) error {
+	btype := o.BucketType
+	if btype == "" {
+		btype = c.btype
+	}
 	path := "/riak/" + o.Bucket
-	req, err := http.NewRequest("POST", c.host+path, o.Body)
+	if btype != "" {
+		path = bucketPath(btype, o.Bucket) + "/keys"
+	}
+	req, err := c.newreq(ctx, "POST", path, o.Body)
 	if err != nil {
 		return err
 	}
@@ -31,7 +44,8 @@ func (c *Client) CreateObject(o *Object, opts map[string]string) error {
 	query.Set("returnbody", "true")
 	req.URL.RawQuery = query.Encode()
 
-	res, err := c.cl.Do(req)
+	// POST isn't idempotent, so only retry if asked to
+	res, err := c.sendRetry(req, c.retry != nil && c.retry.RetryCreate)
 	if err != nil {
 		return err
 	}
@@ -40,6 +54,7 @@ func (c *Client) CreateObject(o *Object, opts map[string]string) error {
 		// this is what we wanted
 		loc := res.Header.Get("Location")
 		o.Key = strings.TrimPrefix(loc, path+"/")
+		o.BucketType = btype
 		return o.fromResponse(res.Header, nil)
 	case 400:
 		res.Body.Close()
@@ -65,58 +80,36 @@ func (c *Client) CreateObject(o *Object, opts map[string]string) error {
 // Merge is successful ONLY if the object in question has not been changed
 // since the last read. ErrModified is returned if there has been a change
 // since 'o' has been retrieved. You can call c.GetUpdate and then re-try
-// the store. Merge will update the object's Vlock and Etag fields.
+// the store, or use Modify, which does so automatically. Merge will update
+// the object's Vlock and Etag fields.
 func (c *Client) Merge(o *Object, opts map[string]string) error {
-	//TODO
-	req, err := http.NewRequest("PUT", c.host+o.path(), o.Body)
-	if err != nil {
-		return err
-	}
-	query := make(url.Values)
-	if opts != nil {
-		for key, val := range opts {
-			query.Set(key, val)
-		}
-	}
-	query.Set("returnbody", "true")
-	req.URL.RawQuery = query.Encode()
-
-	o.writeheader(req.Header)
-	req.Header.Set("If-Match", o.eTag)
-	req.Header.Set("X-Riak-ClientId", c.id)
-
-	res, err := c.cl.Do(req)
-	if err != nil {
-		return err
-	}
+	return c.MergeContext(context.Background(), o, opts)
+}
 
-	switch res.StatusCode {
-	case 200, 201, 204:
-		return o.fromResponse(res.Header, res.Body)
-	case 400:
-		res.Body.Close()
-		return ErrBadRequest
-	case 300:
-		// multiple closes body
-		err = multiple(res)
-		return err
-	case 404:
-		res.Body.Close()
-		return ErrNotFound
-	case 412:
-		res.Body.Close()
-		return ErrModified
-	default:
-		res.Body.Close()
-		return statusCode(res.StatusCode)
-	}
+// MergeContext is like Merge, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) MergeContext(ctx context.Context, o *Object, opts map[string]string) error {
+	return c.put(ctx, o, opts, "If-Match", o.eTag)
 }
 
 // Store stores an object at the object's canonical path (/riak/bucket/key).
 // Doesn't do if-not-modified checks. The object's Vclock and Etag fields
 // are modified to reflect the server's response.
 func (c *Client) Store(o *Object, opts map[string]string) error {
-	req, err := http.NewRequest("PUT", c.host+o.path(), o.Body)
+	return c.StoreContext(context.Background(), o, opts)
+}
+
+// StoreContext is like Store, but the request is
+// abandoned if 'ctx' is done before it completes.
+func (c *Client) StoreContext(ctx context.Context, o *Object, opts map[string]string) error {
+	return c.put(ctx, o, opts, "", "")
+}
+
+// put stores an object, with the precondition
+// header 'cond' (e.g. If-Match) set to 'val' if
+// 'cond' isn't empty
+func (c *Client) put(ctx context.Context, o *Object, opts map[string]string, cond string, val string) error {
+	req, err := c.newreq(ctx, "PUT", c.objpath(o), o.Body)
 	if err != nil {
 		return err
 	}
@@ -130,9 +123,11 @@ func (c *Client) Store(o *Object, opts map[string]string) error {
 	req.URL.RawQuery = query.Encode()
 
 	o.writeheader(req.Header)
-	req.Header.Set("X-Riak-ClientId", c.id)
+	if cond != "" {
+		req.Header.Set(cond, val)
+	}
 
-	res, err := c.cl.Do(req)
+	res, err := c.send(req)
 	if err != nil {
 		return err
 	}
@@ -147,6 +142,9 @@ func (c *Client) Store(o *Object, opts map[string]string) error {
 	case 300:
 		// multiple closes body
 		return multiple(res)
+	case 404:
+		res.Body.Close()
+		return ErrNotFound
 	case 412:
 		res.Body.Close()
 		return ErrModified
@@ -0,0 +1,199 @@
+package riak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// KeyStream iterates over keys as riak streams them, without
+// holding the whole result set in memory. Keys are only read
+// off the connection as fast as Next is called. Always call
+// Close when done with a stream, even if Next returned false.
+//
+//	ks, err := c.StreamBucketKeys("users")
+//	if err != nil {
+//		...
+//	}
+//	defer ks.Close()
+//	for ks.Next() {
+//		fmt.Println(ks.Key())
+//	}
+//	if err := ks.Err(); err != nil {
+//		...
+//	}
+type KeyStream struct {
+	body  io.ReadCloser
+	chunk func() (*streamChunk, error) // reads the next chunk
+	page  []IndexTerm
+	cur   IndexTerm
+	cont  string
+	done  bool
+	err   error
+}
+
+// one chunk of a streamed response
+type streamChunk struct {
+	Keys         []string    `json:"keys"`
+	Results      []IndexTerm `json:"results"`
+	Continuation string      `json:"continuation"`
+	Error        string      `json:"error"`
+}
+
+// Next advances the stream, returning false when
+// there are no more keys or an error occurred.
+func (s *KeyStream) Next() bool {
+	for len(s.page) == 0 {
+		if s.done {
+			return false
+		}
+		c, err := s.chunk()
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			s.Close()
+			return false
+		}
+		if c.Error != "" {
+			s.err = errors.New("riak: " + c.Error)
+			s.Close()
+			return false
+		}
+		if c.Continuation != "" {
+			s.cont = c.Continuation
+		}
+		if c.Results != nil {
+			s.page = c.Results
+		} else {
+			s.page = make([]IndexTerm, len(c.Keys))
+			for i, k := range c.Keys {
+				s.page[i].Key = k
+			}
+		}
+	}
+	s.cur, s.page = s.page[0], s.page[1:]
+	return true
+}
+
+// Key returns the current key
+func (s *KeyStream) Key() string { return s.cur.Key }
+
+// Term returns the index term of the current key
+// for index queries that use ReturnTerms
+func (s *KeyStream) Term() string { return s.cur.Term }
+
+// Continuation returns the continuation sent at the end
+// of an index query that used MaxResults, if there are
+// more results. It is only valid once Next returns false.
+func (s *KeyStream) Continuation() string { return s.cont }
+
+// Err returns the error that ended the stream, if any.
+// Errors that riak reports in the middle of a stream
+// are returned here.
+func (s *KeyStream) Err() error { return s.err }
+
+// Close stops the stream and releases the connection.
+func (s *KeyStream) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	return s.body.Close()
+}
+
+// StreamBucketKeys lists the keys in a bucket using riak's
+// streaming mode (keys=stream). Like ListBucketKeys, this is
+// expensive on large clusters.
+func (c *Client) StreamBucketKeys(bucket string) (*KeyStream, error) {
+	return c.StreamBucketKeysContext(context.Background(), bucket)
+}
+
+// StreamBucketKeysContext is like StreamBucketKeys, but the stream
+// ends with the context's error if 'ctx' is done.
+func (c *Client) StreamBucketKeysContext(ctx context.Context, bucket string) (*KeyStream, error) {
+	res, err := c.do(ctx, "GET", bucketPath(c.btype, bucket)+"/keys?keys=stream", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := streamStatus(res); err != nil {
+		return nil, err
+	}
+	return jsonStream(res.Body), nil
+}
+
+// StreamQuery performs a secondary index query using
+// riak's streaming mode (stream=true).
+func (c *Client) StreamQuery(q *IndexQuery) (*KeyStream, error) {
+	return c.StreamQueryContext(context.Background(), q)
+}
+
+// StreamQueryContext is like StreamQuery, but the stream
+// ends with the context's error if 'ctx' is done.
+func (c *Client) StreamQueryContext(ctx context.Context, q *IndexQuery) (*KeyStream, error) {
+	if len(q.args) == 0 {
+		return nil, errors.New("riak: index query needs Equal or Range")
+	}
+	v := q.query()
+	v.Set("stream", "true")
+	res, err := c.do(ctx, "GET", ipath(c.btype, q.bucket, q.index, q.args...)+"?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := streamStatus(res); err != nil {
+		return nil, err
+	}
+	mtype, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mtype, "multipart/") {
+		// not every transport streams
+		return jsonStream(res.Body), nil
+	}
+	mpr := multipart.NewReader(res.Body, params["boundary"])
+	return &KeyStream{
+		body: res.Body,
+		chunk: func() (*streamChunk, error) {
+			part, err := mpr.NextPart()
+			if err != nil {
+				return nil, err
+			}
+			c := new(streamChunk)
+			err = json.NewDecoder(part).Decode(c)
+			return c, err
+		},
+	}, nil
+}
+
+// a stream of concatenated JSON objects
+func jsonStream(body io.ReadCloser) *KeyStream {
+	dec := json.NewDecoder(body)
+	return &KeyStream{
+		body: body,
+		chunk: func() (*streamChunk, error) {
+			c := new(streamChunk)
+			err := dec.Decode(c)
+			return c, err
+		},
+	}
+}
+
+func streamStatus(res *http.Response) error {
+	if res.StatusCode == 200 {
+		return nil
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case 400:
+		return ErrBadRequest
+	case 404:
+		return ErrNotFound
+	case 503:
+		return ErrTimeout
+	default:
+		return statusCode(res.StatusCode)
+	}
+}
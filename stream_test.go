@@ -0,0 +1,124 @@
+package riak
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamBucketKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("keys") != "stream" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"keys":["a","b"]}{"keys":[]}{"keys":["c"]}`)
+		if r.URL.Path == "/buckets/broken/keys" {
+			io.WriteString(w, `{"error":"timeout"}`)
+		}
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	ks, err := c.StreamBucketKeys("testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for ks.Next() {
+		keys = append(keys, ks.Key())
+	}
+	ks.Close()
+	if ks.Err() != nil {
+		t.Fatal(ks.Err())
+	}
+	if len(keys) != 3 || keys[0] != "a" || keys[2] != "c" {
+		t.Errorf("unexpected keys %q", keys)
+	}
+
+	// errors mid-stream show up on the iterator
+	ks, err = c.StreamBucketKeys("broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for ks.Next() {
+		n++
+	}
+	ks.Close()
+	if n != 3 || ks.Err() == nil {
+		t.Errorf("expected 3 keys and an error; got %d and %v", n, ks.Err())
+	}
+}
+
+func TestStreamQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "true" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/mixed; boundary=chunk")
+		io.WriteString(w, "\r\n--chunk\r\nContent-Type: application/json\r\n\r\n"+
+			`{"results":[{"1":"one"},{"2":"two"}]}`+
+			"\r\n--chunk\r\nContent-Type: application/json\r\n\r\n"+
+			`{"continuation":"next"}`+
+			"\r\n--chunk--\r\n")
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, "testClient")
+
+	ks, err := c.StreamQuery(NewIndexQuery("testing", "num_int").Range("1", "2").ReturnTerms().MaxResults(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	var terms, keys []string
+	for ks.Next() {
+		terms = append(terms, ks.Term())
+		keys = append(keys, ks.Key())
+	}
+	if ks.Err() != nil {
+		t.Fatal(ks.Err())
+	}
+	if len(keys) != 2 || keys[1] != "two" || terms[1] != "2" {
+		t.Errorf("unexpected results %q %q", terms, keys)
+	}
+	if ks.Continuation() != "next" {
+		t.Errorf("expected continuation %q; got %q", "next", ks.Continuation())
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"keys":["a"]}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+	c := NewClient(srv.URL, "testClient")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ks, err := c.StreamBucketKeysContext(ctx, "testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	if !ks.Next() || ks.Key() != "a" {
+		t.Fatal("expected the first key")
+	}
+	cancel()
+	if ks.Next() {
+		t.Fatal("expected the stream to end")
+	}
+	if ks.Err() != context.Canceled {
+		t.Errorf("expected context.Canceled; got %v", ks.Err())
+	}
+}